@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+var (
+	chartDir        string
+	chartValueFiles []string
+	chartNamespace  string
+)
+
+// Kind order used by helm when writing manifests (releaseutil.InstallOrder)
+var helmInstallOrder = []string{
+	"Namespace", "NetworkPolicy", "ResourceQuota", "LimitRange", "PodSecurityPolicy",
+	"PodDisruptionBudget", "ServiceAccount", "Secret", "SecretList", "ConfigMap",
+	"StorageClass", "PersistentVolume", "PersistentVolumeClaim", "CustomResourceDefinition",
+	"ClusterRole", "ClusterRoleList", "ClusterRoleBinding", "ClusterRoleBindingList",
+	"Role", "RoleList", "RoleBinding", "RoleBindingList", "Service", "DaemonSet", "Pod",
+	"ReplicationController", "ReplicaSet", "Deployment", "HorizontalPodAutoscaler",
+	"StatefulSet", "Job", "CronJob", "IngressClass", "Ingress", "APIService",
+}
+
+var manifestSeparator = regexp.MustCompile(`(?:^|\s*\n)---\s*`)
+
+var manifestKindPattern = regexp.MustCompile(`(?m)^kind:\s*(\S+)`)
+
+// A chart loaded from a directory or the embedded copy
+type helmChart struct {
+	metadata  map[string]interface{}
+	values    map[string]interface{}
+	templates map[string]string
+}
+
+// A single rendered document with the template it came from
+type renderedManifest struct {
+	source  string
+	kind    string
+	content string
+}
+
+func newChartCommand() *cobra.Command {
+	chartCmd := &cobra.Command{
+		Use:   "chart",
+		Short: "Work with the bundled Helm chart",
+	}
+
+	renderCmd := &cobra.Command{
+		Use:   "render [RELEASE_NAME]",
+		Short: "Render the chart like helm template, without the helm binary",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runChartRender,
+	}
+	renderCmd.Flags().StringArrayVarP(&chartValueFiles, "values", "f", []string{}, "Values file (can be repeated, later files win)")
+	renderCmd.Flags().StringVar(&chartDir, "chart", "", "Chart directory (default: the bundled chart)")
+	renderCmd.Flags().StringVarP(&chartNamespace, "namespace", "n", "default", "Release namespace")
+	renderCmd.Flags().StringVar(&kubeVersion, "kube-version", defaultKubeVersion, "Kubernetes version for .Capabilities.KubeVersion")
+
+	chartCmd.AddCommand(renderCmd)
+	return chartCmd
+}
+
+func runChartRender(cmd *cobra.Command, args []string) error {
+	// helm template uses the same placeholder when no name is given
+	releaseName := "release-name"
+	if len(args) == 1 {
+		releaseName = args[0]
+	}
+
+	var chartFS fs.FS
+	if chartDir != "" {
+		chartFS = os.DirFS(chartDir)
+	} else {
+		sub, err := fs.Sub(bundledChart, bundledChartDir)
+		if err != nil {
+			return err
+		}
+		chartFS = sub
+	}
+
+	chart, err := loadChart(chartFS)
+	if err != nil {
+		return err
+	}
+
+	var overrides []map[string]interface{}
+	for _, file := range chartValueFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read values file: %w", err)
+		}
+		values, err := parseChartValues(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse values file %s: %w", file, err)
+		}
+		overrides = append(overrides, values)
+	}
+
+	manifests, err := renderChart(chart, releaseName, chartNamespace, overrides...)
+	if err != nil {
+		return err
+	}
+	fmt.Print(formatRenderedManifests(manifests))
+	return nil
+}
+
+// Read Chart.yaml, values.yaml and templates/ from a chart
+func loadChart(chartFS fs.FS) (*helmChart, error) {
+	chart := &helmChart{templates: map[string]string{}}
+
+	data, err := fs.ReadFile(chartFS, "Chart.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Chart.yaml: %w", err)
+	}
+	if err := sigsyaml.Unmarshal(data, &chart.metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+
+	chart.values = map[string]interface{}{}
+	if data, err := fs.ReadFile(chartFS, "values.yaml"); err == nil {
+		if chart.values, err = parseChartValues(data); err != nil {
+			return nil, fmt.Errorf("failed to parse values.yaml: %w", err)
+		}
+	}
+
+	entries, err := fs.ReadDir(chartFS, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(chartFS, path.Join("templates", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		chart.templates[entry.Name()] = string(data)
+	}
+	return chart, nil
+}
+
+// Values are decoded like helm does, through JSON
+func parseChartValues(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := sigsyaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
+
+// Merge override values into the chart defaults; null removes a default key
+func coalesceValues(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst))
+	for key, value := range dst {
+		merged[key] = value
+	}
+	for key, value := range src {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := merged[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merged[key] = coalesceValues(dstMap, srcMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// Render every non-partial template and split the output into manifests,
+// ordered like helm template
+func renderChart(chart *helmChart, releaseName, namespace string, overrides ...map[string]interface{}) ([]renderedManifest, error) {
+	values := chart.values
+	for _, override := range overrides {
+		values = coalesceValues(values, override)
+	}
+
+	chartName := fmt.Sprint(chart.metadata["name"])
+	chartObject := map[string]interface{}{
+		"Name":        chart.metadata["name"],
+		"Version":     chart.metadata["version"],
+		"AppVersion":  chart.metadata["appVersion"],
+		"Description": chart.metadata["description"],
+		"Type":        chart.metadata["type"],
+		"APIVersion":  chart.metadata["apiVersion"],
+	}
+	release := map[string]interface{}{
+		"Name":      releaseName,
+		"Namespace": namespace,
+		"Service":   "Helm",
+		"Revision":  1,
+		"IsInstall": true,
+		"IsUpgrade": false,
+	}
+	// The same version the manifests are validated against
+	minor, err := parseKubeVersion(kubeVersion)
+	if err != nil {
+		return nil, err
+	}
+	version := fmt.Sprintf("v1.%d.0", minor)
+	capabilities := map[string]interface{}{
+		"KubeVersion": map[string]interface{}{
+			"Version":    version,
+			"GitVersion": version,
+			"Major":      "1",
+			"Minor":      strconv.Itoa(minor),
+		},
+	}
+
+	tmpl := template.New("gotpl").Option("missingkey=zero")
+	funcs := chartFuncs()
+	funcs["include"] = func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	funcs["tpl"] = func(text string, data interface{}) (string, error) {
+		t, err := tmpl.Clone()
+		if err != nil {
+			return "", err
+		}
+		t, err = t.New("tpl").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return strings.ReplaceAll(buf.String(), "<no value>", ""), nil
+	}
+	tmpl.Funcs(funcs)
+
+	var names []string
+	for file := range chart.templates {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+
+	for _, file := range names {
+		name := path.Join(chartName, "templates", file)
+		if _, err := tmpl.New(name).Parse(chart.templates[file]); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+	}
+
+	var manifests []renderedManifest
+	for _, file := range names {
+		// Partials and non-manifest files such as NOTES.txt produce no output
+		if strings.HasPrefix(file, "_") || !(strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml") || strings.HasSuffix(file, ".tpl")) {
+			continue
+		}
+		name := path.Join(chartName, "templates", file)
+		data := map[string]interface{}{
+			"Values":       values,
+			"Release":      release,
+			"Chart":        chartObject,
+			"Capabilities": capabilities,
+			"Template": map[string]interface{}{
+				"Name":     name,
+				"BasePath": path.Join(chartName, "templates"),
+			},
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %w", name, err)
+		}
+		output := strings.ReplaceAll(buf.String(), "<no value>", "")
+
+		for _, doc := range manifestSeparator.Split(output, -1) {
+			doc = strings.TrimSpace(doc)
+			if doc == "" {
+				continue
+			}
+			kind := ""
+			if m := manifestKindPattern.FindStringSubmatch(doc); m != nil {
+				kind = m[1]
+			}
+			manifests = append(manifests, renderedManifest{source: name, kind: kind, content: doc})
+		}
+	}
+
+	sortByInstallOrder(manifests)
+	return manifests, nil
+}
+
+// Known kinds first in install order, then unknown kinds alphabetically
+func sortByInstallOrder(manifests []renderedManifest) {
+	order := map[string]int{}
+	for i, kind := range helmInstallOrder {
+		order[kind] = i
+	}
+	sort.SliceStable(manifests, func(i, j int) bool {
+		a, aKnown := order[manifests[i].kind]
+		b, bKnown := order[manifests[j].kind]
+		switch {
+		case aKnown && bKnown:
+			return a < b
+		case aKnown != bKnown:
+			return aKnown
+		default:
+			return manifests[i].kind < manifests[j].kind
+		}
+	})
+}
+
+func formatRenderedManifests(manifests []renderedManifest) string {
+	var b strings.Builder
+	for _, m := range manifests {
+		fmt.Fprintf(&b, "---\n# Source: %s\n%s\n", m.source, m.content)
+	}
+	return b.String()
+}
+
+// The subset of sprig functions used by common chart templates
+func chartFuncs() template.FuncMap {
+	return template.FuncMap{
+		"default": func(d interface{}, given ...interface{}) interface{} {
+			if len(given) == 0 || isEmptyValue(given[0]) {
+				return d
+			}
+			return given[0]
+		},
+		"empty": isEmptyValue,
+		"coalesce": func(v ...interface{}) interface{} {
+			for _, val := range v {
+				if !isEmptyValue(val) {
+					return val
+				}
+			}
+			return nil
+		},
+		"ternary": func(vt, vf interface{}, v bool) interface{} {
+			if v {
+				return vt
+			}
+			return vf
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if v == nil {
+				return nil, errors.New(msg)
+			}
+			if s, ok := v.(string); ok && s == "" {
+				return nil, errors.New(msg)
+			}
+			return v, nil
+		},
+		"trunc": func(c int, s string) string {
+			if c < 0 && len(s)+c > 0 {
+				return s[len(s)+c:]
+			}
+			if c >= 0 && len(s) > c {
+				return s[:c]
+			}
+			return s
+		},
+		"trim":       strings.TrimSpace,
+		"trimSuffix": func(a, s string) string { return strings.TrimSuffix(s, a) },
+		"trimPrefix": func(a, s string) string { return strings.TrimPrefix(s, a) },
+		"hasPrefix":  func(a, s string) bool { return strings.HasPrefix(s, a) },
+		"hasSuffix":  func(a, s string) bool { return strings.HasSuffix(s, a) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"replace":    func(old, new, src string) string { return strings.ReplaceAll(src, old, new) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"join": func(sep string, v interface{}) string {
+			var parts []string
+			if list, ok := v.([]interface{}); ok {
+				for _, item := range list {
+					parts = append(parts, toString(item))
+				}
+			}
+			return strings.Join(parts, sep)
+		},
+		"quote": func(str ...interface{}) string {
+			var out []string
+			for _, s := range str {
+				if s != nil {
+					out = append(out, fmt.Sprintf("%q", toString(s)))
+				}
+			}
+			return strings.Join(out, " ")
+		},
+		"squote": func(str ...interface{}) string {
+			var out []string
+			for _, s := range str {
+				if s != nil {
+					out = append(out, fmt.Sprintf("'%v'", s))
+				}
+			}
+			return strings.Join(out, " ")
+		},
+		"indent": indentText,
+		"nindent": func(spaces int, v string) string {
+			return "\n" + indentText(spaces, v)
+		},
+		// Unlike helm, a value that can't be encoded or decoded fails the
+		// render instead of leaving an empty string or the error text behind
+		"toYaml": func(v interface{}) (string, error) {
+			data, err := sigsyaml.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("toYaml: %w", err)
+			}
+			return strings.TrimSuffix(string(data), "\n"), nil
+		},
+		"toJson": func(v interface{}) string {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		},
+		"toString": toString,
+		"b64enc":   func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec": func(s string) (string, error) {
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return "", fmt.Errorf("b64dec: %w", err)
+			}
+			return string(data), nil
+		},
+		"list": func(v ...interface{}) []interface{} { return v },
+		"dict": func(v ...interface{}) map[string]interface{} {
+			dict := map[string]interface{}{}
+			for i := 0; i+1 < len(v); i += 2 {
+				dict[toString(v[i])] = v[i+1]
+			}
+			return dict
+		},
+		"hasKey": func(d map[string]interface{}, key string) bool {
+			_, ok := d[key]
+			return ok
+		},
+	}
+}
+
+func indentText(spaces int, v string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(v, "\n", "\n"+pad)
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Sprig's notion of empty: zero values and empty collections
+func isEmptyValue(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return val == ""
+	case bool:
+		return !val
+	case int:
+		return val == 0
+	case int64:
+		return val == 0
+	case float64:
+		return val == 0
+	case []interface{}:
+		return len(val) == 0
+	case map[string]interface{}:
+		return len(val) == 0
+	default:
+		return false
+	}
+}
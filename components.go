@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ComponentConfig declares one Deployment (or StatefulSet) of the app, e.g.
+// the webserver, a queue worker or a scheduler
+type ComponentConfig struct {
+	Name           string            `yaml:"name"`
+	Tier           string            `yaml:"tier"`
+	Workload       string            `yaml:"workload"`
+	Replicas       *int              `yaml:"replicas"`
+	Command        []string          `yaml:"command"`
+	Args           []string          `yaml:"args"`
+	Port           *int              `yaml:"port"`
+	Resources      ResourcesSection  `yaml:"resources"`
+	Sidecars       []ContainerConfig `yaml:"sidecars"`
+	InitContainers []ContainerConfig `yaml:"initContainers"`
+	Volumes        []Volume          `yaml:"volumes"`
+	VolumeMounts   []VolumeMount     `yaml:"volumeMounts"`
+}
+
+var componentTiers = []string{"webserver", "worker", "scheduler"}
+
+// Components with defaults filled in: replicas and port are always set (port
+// 0 exposes nothing) and resources fall back to the app-wide values
+var resolvedComponents []ComponentConfig
+
+// Resolve the components from the config file. Without any, the app is the
+// single webserver described by the flags (named after the preset, or node).
+func resolveComponents() error {
+	if len(componentConfigs) == 0 {
+		resolvedComponents = []ComponentConfig{{
+			Name:           defaultComponentName(),
+			Tier:           "webserver",
+			Workload:       workload,
+			Replicas:       intPtr(replicas),
+			Port:           intPtr(containerPort),
+			Resources:      appResources(),
+			Sidecars:       sidecarConfigs,
+			InitContainers: initContainerConfigs,
+			Volumes:        volumeConfigs,
+			VolumeMounts:   appVolumeMounts,
+		}}
+		resolvedComponents[0] = withStatefulSetMounts(withPresetContainers(resolvedComponents[0]))
+		return validateContainers(resolvedComponents[0])
+	}
+
+	if len(sidecarConfigs) > 0 || len(initContainerConfigs) > 0 || len(volumeConfigs) > 0 || len(appVolumeMounts) > 0 {
+		return fmt.Errorf("with components, declare sidecars, initContainers, volumes and volumeMounts per component")
+	}
+
+	resolvedComponents = nil
+	names := map[string]bool{}
+	for _, c := range componentConfigs {
+		if c.Name == "" {
+			return fmt.Errorf("component without a name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate component name %q", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.Tier == "" {
+			c.Tier = "webserver"
+		}
+		if !containsString(componentTiers, c.Tier) {
+			return fmt.Errorf("component %s: unknown tier %q (expected %s)", c.Name, c.Tier, strings.Join(componentTiers, ", "))
+		}
+		if c.Workload == "" {
+			c.Workload = workload
+		}
+		if !containsString(workloadKinds, c.Workload) {
+			return fmt.Errorf("component %s: unknown workload %q (expected %s)", c.Name, c.Workload, strings.Join(workloadKinds, ", "))
+		}
+		if c.Replicas == nil {
+			c.Replicas = intPtr(replicas)
+		}
+
+		// Only webservers expose the container port unless one is given
+		if c.Port == nil {
+			port := 0
+			if c.Tier == "webserver" {
+				port = containerPort
+			}
+			c.Port = &port
+		}
+		if *c.Port < 0 || *c.Port > 65535 {
+			return fmt.Errorf("component %s: invalid port %d", c.Name, *c.Port)
+		}
+
+		c.Resources = mergeResources(c.Resources, appResources())
+		c = withStatefulSetMounts(withPresetContainers(c))
+		if err := validateContainers(c); err != nil {
+			return err
+		}
+		resolvedComponents = append(resolvedComponents, c)
+	}
+	return nil
+}
+
+// Mount the volume claim templates into the app container of a StatefulSet
+func withStatefulSetMounts(c ComponentConfig) ComponentConfig {
+	if c.Workload == "statefulset" {
+		c.VolumeMounts = append(append([]VolumeMount(nil), c.VolumeMounts...), volumeClaimMounts()...)
+	}
+	return c
+}
+
+// Resources from the --resources-* flags
+func appResources() ResourcesSection {
+	return ResourcesSection{
+		Requests: ResourceValues{CPU: resourceRequestsCPU, Memory: resourceRequestsMemory},
+		Limits:   ResourceValues{CPU: resourceLimitsCPU, Memory: resourceLimitsMemory},
+	}
+}
+
+// Fill the values missing in resources from defaults
+func mergeResources(resources, defaults ResourcesSection) ResourcesSection {
+	pick := func(value, fallback string) string {
+		if value != "" {
+			return value
+		}
+		return fallback
+	}
+	return ResourcesSection{
+		Requests: ResourceValues{
+			CPU:    pick(resources.Requests.CPU, defaults.Requests.CPU),
+			Memory: pick(resources.Requests.Memory, defaults.Requests.Memory),
+		},
+		Limits: ResourceValues{
+			CPU:    pick(resources.Limits.CPU, defaults.Limits.CPU),
+			Memory: pick(resources.Limits.Memory, defaults.Limits.Memory),
+		},
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Name of the component's Deployment and app container
+func (c ComponentConfig) deploymentName() string {
+	return fmt.Sprintf("%s-%s", appName, c.Name)
+}
+
+// Labels selecting the component's pods
+func (c ComponentConfig) selectorLabels() map[string]string {
+	labels := appSelectorLabels()
+	labels["tier"] = c.Tier
+	labels["layer"] = c.Name
+	return labels
+}
+
+// The component HPA and VPA scale: the first one
+func primaryComponent() ComponentConfig {
+	return resolvedComponents[0]
+}
+
+// Components that expose a port and get a Service
+func exposedComponents() []ComponentConfig {
+	var exposed []ComponentConfig
+	for _, c := range resolvedComponents {
+		if *c.Port > 0 {
+			exposed = append(exposed, c)
+		}
+	}
+	return exposed
+}
+
+// The first exposed component's Service is named after the app (and gets the
+// Ingress); the others are suffixed with the component name
+func serviceName(c ComponentConfig) string {
+	if exposed := exposedComponents(); len(exposed) > 0 && exposed[0].Name == c.Name {
+		return appName
+	}
+	return c.deploymentName()
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file schema identifiers
+const (
+	configAPIVersion = "kcg/v1"
+	configKind       = "AppConfig"
+)
+
+// AppConfig is the declarative equivalent of the CLI flags, loaded with --config.
+// Pointer fields distinguish "not set" from zero values so the file can turn
+// off defaults such as create-service-account.
+type AppConfig struct {
+	APIVersion      string                        `yaml:"apiVersion"`
+	Kind            string                        `yaml:"kind"`
+	Preset          string                        `yaml:"preset"`
+	PresetDir       string                        `yaml:"presetDir"`
+	App             AppSection                    `yaml:"app"`
+	Image           ImageSection                  `yaml:"image"`
+	ServiceAccount  ServiceAccountSection         `yaml:"serviceAccount"`
+	Resources       ResourcesSection              `yaml:"resources"`
+	Ingress         IngressSection                `yaml:"ingress"`
+	VPA             ToggleSection                 `yaml:"vpa"`
+	ResourceQuota   ToggleSection                 `yaml:"resourceQuota"`
+	HPA             HPASection                    `yaml:"hpa"`
+	StatefulSet     StatefulSetSection            `yaml:"statefulSet"`
+	PDB             PDBSection                    `yaml:"pdb"`
+	NetworkPolicy   NetworkPolicySection          `yaml:"networkPolicy"`
+	Probes          ProbesSection                 `yaml:"probes"`
+	Env             []EnvVarConfig                `yaml:"env"`
+	EnvFiles        EnvFilesSection               `yaml:"envFiles"`
+	Secrets         SecretsSection                `yaml:"secrets"`
+	Scheduling      SchedulingConfig              `yaml:"scheduling"`
+	Sidecars        []ContainerConfig             `yaml:"sidecars"`
+	InitContainers  []ContainerConfig             `yaml:"initContainers"`
+	Volumes         []Volume                      `yaml:"volumes"`
+	PVCs            []PersistentVolumeClaimConfig `yaml:"persistentVolumeClaims"`
+	Components      []ComponentConfig             `yaml:"components"`
+	Jobs            []JobConfig                   `yaml:"jobs"`
+	Environment     string                        `yaml:"environment"`
+	AllEnvironments *bool                         `yaml:"allEnvironments"`
+	Environments    map[string]EnvironmentConfig  `yaml:"environments"`
+	Patches         []PatchConfig                 `yaml:"patches"`
+	Labels          LabelsSection                 `yaml:"labels"`
+	Annotations     AnnotationsSection            `yaml:"annotations"`
+	Output          OutputSection                 `yaml:"output"`
+
+	// Environments to generate, like --environments
+	SelectedEnvironments []string `yaml:"selectedEnvironments"`
+
+	// Environment names in the order they are declared in the file
+	environmentOrder []string
+}
+
+type AppSection struct {
+	Name          string        `yaml:"name"`
+	Namespace     string        `yaml:"namespace"`
+	ContainerPort *int          `yaml:"containerPort"`
+	Replicas      *int          `yaml:"replicas"`
+	Workload      string        `yaml:"workload"`
+	VolumeMounts  []VolumeMount `yaml:"volumeMounts"`
+}
+
+type ImageSection struct {
+	Repository  string   `yaml:"repository"`
+	Tag         string   `yaml:"tag"`
+	PullSecrets []string `yaml:"pullSecrets"`
+}
+
+type ServiceAccountSection struct {
+	Name   string `yaml:"name"`
+	Create *bool  `yaml:"create"`
+}
+
+type ResourcesSection struct {
+	Requests ResourceValues `yaml:"requests"`
+	Limits   ResourceValues `yaml:"limits"`
+}
+
+type ResourceValues struct {
+	CPU    string `yaml:"cpu"`
+	Memory string `yaml:"memory"`
+}
+
+type IngressSection struct {
+	Enabled   *bool  `yaml:"enabled"`
+	Host      string `yaml:"host"`
+	ClassName string `yaml:"className"`
+	TLSSecret string `yaml:"tlsSecret"`
+}
+
+type HPASection struct {
+	Enabled      *bool        `yaml:"enabled"`
+	MinReplicas  *int         `yaml:"minReplicas"`
+	MaxReplicas  *int         `yaml:"maxReplicas"`
+	CPUTarget    *int         `yaml:"cpuTarget"`
+	MemoryTarget *int         `yaml:"memoryTarget"`
+	Behavior     *HPABehavior `yaml:"behavior"`
+}
+
+type ProbesSection struct {
+	Preset    string       `yaml:"preset"`
+	Liveness  *ProbeConfig `yaml:"liveness"`
+	Readiness *ProbeConfig `yaml:"readiness"`
+	Startup   *ProbeConfig `yaml:"startup"`
+}
+
+type ToggleSection struct {
+	Enabled *bool `yaml:"enabled"`
+}
+
+// EnvFilesSection lists dotenv files loaded into the ConfigMap and Secret
+type EnvFilesSection struct {
+	Config         []string `yaml:"config"`
+	Secret         []string `yaml:"secret"`
+	SecretEncoding string   `yaml:"secretEncoding"`
+}
+
+type OutputSection struct {
+	Render         *bool  `yaml:"render"`
+	Dir            string `yaml:"dir"`
+	Format         string `yaml:"format"`
+	HelmValuesOnly *bool  `yaml:"helmValuesOnly"`
+	KubeVersion    string `yaml:"kubeVersion"`
+	SkipValidation *bool  `yaml:"skipValidation"`
+	OutputFormat   string `yaml:"outputFormat"`
+	SingleFile     *bool  `yaml:"singleFile"`
+}
+
+// Load and validate a config file
+func loadConfig(path string) (*AppConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	cfg.resolvePaths(filepath.Dir(path))
+	return cfg, nil
+}
+
+// Make file paths in the config relative to the config file's directory
+func (cfg *AppConfig) resolvePaths(dir string) {
+	resolve := func(paths []string) {
+		for i, p := range paths {
+			if !filepath.IsAbs(p) {
+				paths[i] = filepath.Join(dir, p)
+			}
+		}
+	}
+
+	resolve(cfg.EnvFiles.Config)
+	resolve(cfg.EnvFiles.Secret)
+	if cfg.Secrets.SealedSecrets.Cert != "" && !filepath.IsAbs(cfg.Secrets.SealedSecrets.Cert) {
+		cfg.Secrets.SealedSecrets.Cert = filepath.Join(dir, cfg.Secrets.SealedSecrets.Cert)
+	}
+	if cfg.PresetDir != "" && !filepath.IsAbs(cfg.PresetDir) {
+		cfg.PresetDir = filepath.Join(dir, cfg.PresetDir)
+	}
+	for _, envConfig := range cfg.Environments {
+		resolve(envConfig.EnvFiles.Config)
+		resolve(envConfig.EnvFiles.Secret)
+	}
+	for i, p := range cfg.Patches {
+		if p.Path != "" && !filepath.IsAbs(p.Path) {
+			cfg.Patches[i].Path = filepath.Join(dir, p.Path)
+		}
+	}
+}
+
+// Parse config file contents, rejecting unknown fields and schema versions
+func parseConfig(data []byte) (*AppConfig, error) {
+	var cfg AppConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config file is empty")
+		}
+		return nil, err
+	}
+
+	if cfg.APIVersion != configAPIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q (expected %s)", cfg.APIVersion, configAPIVersion)
+	}
+	if cfg.Kind != configKind {
+		return nil, fmt.Errorf("unsupported kind %q (expected %s)", cfg.Kind, configKind)
+	}
+	for name := range cfg.Environments {
+		if !dns1123LabelPattern.MatchString(name) {
+			return nil, fmt.Errorf("environment %q: name must be a lowercase DNS label", name)
+		}
+	}
+	cfg.environmentOrder = configEnvironmentOrder(data)
+	return &cfg, nil
+}
+
+// configApplier copies config values into the flag variables, skipping any
+// flag that was set explicitly on the command line
+type configApplier struct {
+	changed func(name string) bool
+}
+
+func (a configApplier) str(flag string, dst *string, val string) {
+	if val != "" && !a.changed(flag) {
+		*dst = val
+	}
+}
+
+func (a configApplier) strs(flag string, dst *[]string, val []string) {
+	if len(val) > 0 && !a.changed(flag) {
+		*dst = val
+	}
+}
+
+func (a configApplier) int(flag string, dst *int, val *int) {
+	if val != nil && !a.changed(flag) {
+		*dst = *val
+	}
+}
+
+func (a configApplier) bool(flag string, dst *bool, val *bool) {
+	if val != nil && !a.changed(flag) {
+		*dst = *val
+	}
+}
+
+// Apply config values to the package-level flag variables
+func applyConfig(cfg *AppConfig, changed func(name string) bool) {
+	a := configApplier{changed: changed}
+
+	a.str("app-name", &appName, cfg.App.Name)
+	a.str("namespace", &namespace, cfg.App.Namespace)
+	a.int("container-port", &containerPort, cfg.App.ContainerPort)
+	a.int("replicas", &replicas, cfg.App.Replicas)
+	a.str("workload", &workload, cfg.App.Workload)
+
+	a.str("image-repo", &imageRepo, cfg.Image.Repository)
+	a.str("image-tag", &imageTag, cfg.Image.Tag)
+	a.strs("image-pull-secret", &imagePullSecrets, cfg.Image.PullSecrets)
+
+	a.str("service-account", &serviceAccount, cfg.ServiceAccount.Name)
+	a.bool("create-service-account", &createSA, cfg.ServiceAccount.Create)
+
+	a.str("resources-requests-cpu", &resourceRequestsCPU, cfg.Resources.Requests.CPU)
+	a.str("resources-requests-memory", &resourceRequestsMemory, cfg.Resources.Requests.Memory)
+	a.str("resources-limits-cpu", &resourceLimitsCPU, cfg.Resources.Limits.CPU)
+	a.str("resources-limits-memory", &resourceLimitsMemory, cfg.Resources.Limits.Memory)
+
+	a.bool("ingress-enabled", &ingressEnabled, cfg.Ingress.Enabled)
+	a.str("ingress-host", &ingressHost, cfg.Ingress.Host)
+	a.str("ingress-class", &ingressClass, cfg.Ingress.ClassName)
+	a.str("ingress-tls-secret", &ingressTLSSecret, cfg.Ingress.TLSSecret)
+
+	a.bool("vpa-enabled", &vpaEnabled, cfg.VPA.Enabled)
+	a.bool("resource-quota-enabled", &resourceQuotaEnabled, cfg.ResourceQuota.Enabled)
+
+	a.bool("hpa-enabled", &hpaEnabled, cfg.HPA.Enabled)
+	a.int("hpa-min-replicas", &hpaMinReplicas, cfg.HPA.MinReplicas)
+	a.int("hpa-max-replicas", &hpaMaxReplicas, cfg.HPA.MaxReplicas)
+	a.int("hpa-cpu-target", &hpaCPUTarget, cfg.HPA.CPUTarget)
+	a.int("hpa-memory-target", &hpaMemoryTarget, cfg.HPA.MemoryTarget)
+	if cfg.HPA.Behavior != nil {
+		// Scale-up/scale-down flags are merged on top of these rules
+		hpaBehaviorConfig = *cfg.HPA.Behavior
+	}
+
+	a.str("pod-management-policy", &podManagementPolicy, cfg.StatefulSet.PodManagementPolicy)
+	a.str("statefulset-update-strategy", &statefulSetUpdateStrategy, cfg.StatefulSet.UpdateStrategy)
+	a.int("statefulset-partition", &statefulSetPartition, cfg.StatefulSet.Partition)
+	// Claim template flags are merged on top of these in resolveStatefulSet
+	volumeClaimTemplateConfigs = cfg.StatefulSet.VolumeClaimTemplates
+
+	// An explicit enabled: false also turns off the production default
+	a.bool("pdb-enabled", &pdbEnabled, cfg.PDB.Enabled)
+	if cfg.PDB.Enabled != nil {
+		pdbEnabledSet = true
+	}
+	a.str("pdb-min-available", &pdbMinAvailable, cfg.PDB.MinAvailable)
+	a.str("pdb-max-unavailable", &pdbMaxUnavailable, cfg.PDB.MaxUnavailable)
+
+	a.bool("network-policy-enabled", &networkPolicyEnabled, cfg.NetworkPolicy.Enabled)
+	a.bool("network-policy-default-deny", &networkPolicyDefaultDeny, cfg.NetworkPolicy.DefaultDeny)
+	a.bool("network-policy-allow-same-namespace", &networkPolicyAllowSameNamespace, cfg.NetworkPolicy.AllowSameNamespace)
+	a.bool("network-policy-allow-dns", &networkPolicyAllowDNS, cfg.NetworkPolicy.AllowDNS)
+	a.str("network-policy-ingress-namespace", &networkPolicyIngressNamespace, cfg.NetworkPolicy.IngressController.Namespace)
+	// Egress flags are merged on top of these in resolveNetworkPolicy
+	networkPolicyIngressLabels = cfg.NetworkPolicy.IngressController.PodLabels
+	networkPolicyEgressRules = cfg.NetworkPolicy.Egress
+
+	// Probe flags take precedence over these in resolveProbes
+	a.str("probe-preset", &probePreset, cfg.Probes.Preset)
+	livenessProbeConfig = cfg.Probes.Liveness
+	readinessProbeConfig = cfg.Probes.Readiness
+	startupProbeConfig = cfg.Probes.Startup
+
+	// Env var flags are merged on top of these in resolveEnvVars
+	envVarConfigs[""] = cfg.Env
+	for name, envConfig := range cfg.Environments {
+		envVarConfigs[name] = envConfig.Env
+	}
+
+	// Env file flags without an ENV: prefix replace these in resolveEnvVars
+	envFilesConfig = cfg.EnvFiles
+	a.str("secret-data-encoding", &secretDataEncoding, cfg.EnvFiles.SecretEncoding)
+
+	a.str("secret-format", &secretFormat, cfg.Secrets.Format)
+	a.strs("sops-age-recipient", &sopsAgeRecipients, cfg.Secrets.Sops.AgeRecipients)
+	a.str("sealed-secrets-cert", &sealedSecretsCert, cfg.Secrets.SealedSecrets.Cert)
+	a.str("sealed-secrets-scope", &sealedSecretsScope, cfg.Secrets.SealedSecrets.Scope)
+	a.str("external-secrets-store", &externalSecretsStore, cfg.Secrets.ExternalSecrets.Store)
+	a.str("external-secrets-store-kind", &externalSecretsStoreKind, cfg.Secrets.ExternalSecrets.StoreKind)
+	a.str("external-secrets-remote-key", &externalSecretsRemoteKey, cfg.Secrets.ExternalSecrets.RemoteKey)
+	a.str("external-secrets-refresh-interval", &externalSecretsRefreshInterval, cfg.Secrets.ExternalSecrets.RefreshInterval)
+
+	// Scheduling flags are merged on top of this in resolveScheduling
+	schedulingConfig = cfg.Scheduling
+
+	// Extra containers and volumes are only set in the config file
+	sidecarConfigs = cfg.Sidecars
+	initContainerConfigs = cfg.InitContainers
+	volumeConfigs = cfg.Volumes
+	pvcConfigs = cfg.PVCs
+	appVolumeMounts = cfg.App.VolumeMounts
+	componentConfigs = cfg.Components
+	jobConfigs = cfg.Jobs
+
+	a.str("env", &env, cfg.Environment)
+	a.bool("all-environments", &allEnvironments, cfg.AllEnvironments)
+	a.strs("environments", &selectedEnvironments, cfg.SelectedEnvironments)
+
+	// Environment flags are merged on top of these in resolveEnvironments
+	environmentConfigs = cfg.Environments
+	environmentOrder = cfg.environmentOrder
+	if len(environmentOrder) != len(environmentConfigs) {
+		environmentOrder = sortedEnvironmentNames(environmentConfigs)
+	}
+
+	// Patch flags are applied after these in resolvePatches
+	patchConfigs = cfg.Patches
+
+	// Label and annotation flags are merged on top of these in resolveLabels
+	a.bool("recommended-labels", &recommendedLabels, cfg.Labels.Recommended)
+	a.str("part-of", &partOf, cfg.Labels.PartOf)
+	labelConfig = cfg.Labels
+	annotationConfig = cfg.Annotations
+
+	a.bool("render", &render, cfg.Output.Render)
+	a.str("output-dir", &outputDir, cfg.Output.Dir)
+	a.str("format", &outputFormat, cfg.Output.Format)
+	a.bool("helm-values-only", &helmValuesOnly, cfg.Output.HelmValuesOnly)
+	a.str("kube-version", &kubeVersion, cfg.Output.KubeVersion)
+	a.bool("skip-validation", &skipValidation, cfg.Output.SkipValidation)
+	a.str("output-format", &manifestEncoding, cfg.Output.OutputFormat)
+	a.bool("single-file", &singleFile, cfg.Output.SingleFile)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ContainerConfig is a sidecar or init container declared in the config file
+type ContainerConfig struct {
+	Name         string           `yaml:"name"`
+	Image        string           `yaml:"image"`
+	Command      []string         `yaml:"command,omitempty"`
+	Args         []string         `yaml:"args,omitempty"`
+	Ports        []ContainerPort  `yaml:"ports,omitempty"`
+	Env          []EnvVar         `yaml:"env,omitempty"`
+	UseAppEnv    bool             `yaml:"useAppEnv,omitempty"`
+	Resources    ResourcesSection `yaml:"resources,omitempty"`
+	VolumeMounts []VolumeMount    `yaml:"volumeMounts,omitempty"`
+	// Init containers only: Always keeps it running next to the app (native sidecar)
+	RestartPolicy string `yaml:"restartPolicy,omitempty"`
+}
+
+type VolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+// Volume is a pod volume; one without a source is an emptyDir
+type Volume struct {
+	Name                  string                       `yaml:"name"`
+	EmptyDir              *EmptyDirVolume              `yaml:"emptyDir,omitempty"`
+	ConfigMap             *ConfigMapVolume             `yaml:"configMap,omitempty"`
+	Secret                *SecretVolume                `yaml:"secret,omitempty"`
+	Projected             *ProjectedVolume             `yaml:"projected,omitempty"`
+	PersistentVolumeClaim *PersistentVolumeClaimVolume `yaml:"persistentVolumeClaim,omitempty"`
+}
+
+type EmptyDirVolume struct {
+	Medium    string `yaml:"medium,omitempty"`
+	SizeLimit string `yaml:"sizeLimit,omitempty"`
+}
+
+// ConfigMapVolume mounts a ConfigMap, the app's own when no name is given
+type ConfigMapVolume struct {
+	Name        string      `yaml:"name,omitempty"`
+	Items       []KeyToPath `yaml:"items,omitempty"`
+	DefaultMode *int        `yaml:"defaultMode,omitempty"`
+	Optional    *bool       `yaml:"optional,omitempty"`
+}
+
+// SecretVolume mounts a Secret, the app's own when no name is given
+type SecretVolume struct {
+	SecretName  string      `yaml:"secretName,omitempty"`
+	Items       []KeyToPath `yaml:"items,omitempty"`
+	DefaultMode *int        `yaml:"defaultMode,omitempty"`
+	Optional    *bool       `yaml:"optional,omitempty"`
+}
+
+type KeyToPath struct {
+	Key  string `yaml:"key"`
+	Path string `yaml:"path"`
+	Mode *int   `yaml:"mode,omitempty"`
+}
+
+// ProjectedVolume combines ConfigMaps, Secrets, the downward API and service
+// account tokens in one directory
+type ProjectedVolume struct {
+	Sources     []VolumeProjection `yaml:"sources"`
+	DefaultMode *int               `yaml:"defaultMode,omitempty"`
+}
+
+type VolumeProjection struct {
+	ConfigMap           *ConfigMapVolume           `yaml:"configMap,omitempty"`
+	Secret              *ProjectedSecret           `yaml:"secret,omitempty"`
+	DownwardAPI         *DownwardAPIProjection     `yaml:"downwardAPI,omitempty"`
+	ServiceAccountToken *ServiceAccountTokenSource `yaml:"serviceAccountToken,omitempty"`
+}
+
+// ProjectedSecret is a Secret in a projected volume, which names it name
+// rather than secretName
+type ProjectedSecret struct {
+	Name     string      `yaml:"name,omitempty"`
+	Items    []KeyToPath `yaml:"items,omitempty"`
+	Optional *bool       `yaml:"optional,omitempty"`
+}
+
+type DownwardAPIProjection struct {
+	Items []DownwardAPIFile `yaml:"items"`
+}
+
+type DownwardAPIFile struct {
+	Path             string            `yaml:"path"`
+	FieldRef         map[string]string `yaml:"fieldRef,omitempty"`
+	ResourceFieldRef map[string]string `yaml:"resourceFieldRef,omitempty"`
+}
+
+type ServiceAccountTokenSource struct {
+	Audience          string `yaml:"audience,omitempty"`
+	ExpirationSeconds *int   `yaml:"expirationSeconds,omitempty"`
+	Path              string `yaml:"path"`
+}
+
+type PersistentVolumeClaimVolume struct {
+	ClaimName string `yaml:"claimName"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+// Check a component's sidecars, init containers and volumes before generating anything
+func validateContainers(c ComponentConfig) error {
+	names := map[string]bool{c.deploymentName(): true}
+	check := func(kind string, container ContainerConfig) error {
+		if container.Name == "" {
+			return fmt.Errorf("%s without a name", kind)
+		}
+		if names[container.Name] {
+			return fmt.Errorf("duplicate container name %q", container.Name)
+		}
+		names[container.Name] = true
+		if container.Image == "" {
+			return fmt.Errorf("%s %s requires an image", kind, container.Name)
+		}
+		return nil
+	}
+
+	for _, sidecar := range c.Sidecars {
+		if err := check("sidecar", sidecar); err != nil {
+			return err
+		}
+		if sidecar.RestartPolicy != "" {
+			return fmt.Errorf("sidecar %s: restartPolicy is only supported on init containers", sidecar.Name)
+		}
+	}
+	for _, init := range c.InitContainers {
+		if err := check("init container", init); err != nil {
+			return err
+		}
+		if init.RestartPolicy != "" && init.RestartPolicy != "Always" {
+			return fmt.Errorf("init container %s: restartPolicy must be Always", init.Name)
+		}
+	}
+
+	volumes := map[string]bool{}
+	for _, v := range c.Volumes {
+		if volumes[v.Name] {
+			return fmt.Errorf("duplicate volume name %q", v.Name)
+		}
+		volumes[v.Name] = true
+		if err := validateVolume(v); err != nil {
+			return fmt.Errorf("volume %s: %w", v.Name, err)
+		}
+	}
+
+	// StatefulSet pods also get a volume per claim template
+	if c.Workload == "statefulset" {
+		for _, claim := range resolvedVolumeClaimTemplates {
+			if volumes[claim.Name] {
+				return fmt.Errorf("volume claim template %q has the same name as a volume", claim.Name)
+			}
+			volumes[claim.Name] = true
+		}
+	}
+
+	// Every mount must refer to a declared volume
+	checkMounts := func(container string, mounts []VolumeMount) error {
+		for _, m := range mounts {
+			if !volumes[m.Name] {
+				return fmt.Errorf("container %s mounts undeclared volume %q", container, m.Name)
+			}
+			if m.MountPath == "" {
+				return fmt.Errorf("container %s: mount of volume %q requires a mountPath", container, m.Name)
+			}
+		}
+		return nil
+	}
+	if err := checkMounts(c.deploymentName(), c.VolumeMounts); err != nil {
+		return err
+	}
+	for _, container := range append(append([]ContainerConfig{}, c.Sidecars...), c.InitContainers...) {
+		if err := checkMounts(container.Name, container.VolumeMounts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check that a volume has at most one source and that the source is complete
+func validateVolume(v Volume) error {
+	sources := 0
+	for _, set := range []bool{v.EmptyDir != nil, v.ConfigMap != nil, v.Secret != nil, v.Projected != nil, v.PersistentVolumeClaim != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("set only one of emptyDir, configMap, secret, projected and persistentVolumeClaim")
+	}
+
+	switch {
+	case v.ConfigMap != nil:
+		return validateKeyPaths(v.ConfigMap.Items)
+	case v.Secret != nil:
+		return validateKeyPaths(v.Secret.Items)
+	case v.PersistentVolumeClaim != nil:
+		if v.PersistentVolumeClaim.ClaimName == "" {
+			return fmt.Errorf("persistentVolumeClaim requires a claimName")
+		}
+	case v.Projected != nil:
+		if len(v.Projected.Sources) == 0 {
+			return fmt.Errorf("projected volumes require at least one source")
+		}
+		for _, source := range v.Projected.Sources {
+			kinds := 0
+			for _, set := range []bool{source.ConfigMap != nil, source.Secret != nil, source.DownwardAPI != nil, source.ServiceAccountToken != nil} {
+				if set {
+					kinds++
+				}
+			}
+			if kinds != 1 {
+				return fmt.Errorf("each projected source needs exactly one of configMap, secret, downwardAPI and serviceAccountToken")
+			}
+			switch {
+			case source.ConfigMap != nil:
+				if source.ConfigMap.DefaultMode != nil {
+					return fmt.Errorf("set defaultMode on the projected volume, not its sources")
+				}
+				if err := validateKeyPaths(source.ConfigMap.Items); err != nil {
+					return err
+				}
+			case source.Secret != nil:
+				if err := validateKeyPaths(source.Secret.Items); err != nil {
+					return err
+				}
+			case source.DownwardAPI != nil:
+				for _, item := range source.DownwardAPI.Items {
+					if item.Path == "" || (len(item.FieldRef) == 0) == (len(item.ResourceFieldRef) == 0) {
+						return fmt.Errorf("downwardAPI items need a path and either fieldRef or resourceFieldRef")
+					}
+				}
+			case source.ServiceAccountToken != nil:
+				if source.ServiceAccountToken.Path == "" {
+					return fmt.Errorf("serviceAccountToken requires a path")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateKeyPaths(items []KeyToPath) error {
+	for _, item := range items {
+		if item.Key == "" || item.Path == "" {
+			return fmt.Errorf("items need a key and a path")
+		}
+	}
+	return nil
+}
+
+// Build sidecar or init containers with the same hardening as the app container
+func buildContainers(configs []ContainerConfig, envFrom []map[string]interface{}) []Container {
+	var containers []Container
+	for _, c := range configs {
+		container := Container{
+			Name:            c.Name,
+			Image:           c.Image,
+			ImagePullPolicy: "IfNotPresent",
+			Command:         c.Command,
+			Args:            c.Args,
+			Ports:           c.Ports,
+			Env:             c.Env,
+			Resources:       resourceRequirements(c.Resources),
+			SecurityContext: containerSecurityContext(),
+			VolumeMounts:    c.VolumeMounts,
+			RestartPolicy:   c.RestartPolicy,
+		}
+		if c.UseAppEnv {
+			container.EnvFrom = envFrom
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// Pod volumes, defaulting volumes without a source to emptyDir and
+// ConfigMaps and Secrets without a name to the app's own
+func buildVolumes(configs []Volume) []Volume {
+	var volumes []Volume
+	for _, v := range configs {
+		switch {
+		case v.ConfigMap != nil:
+			configMap := *v.ConfigMap
+			if configMap.Name == "" {
+				configMap.Name = appName
+			}
+			v.ConfigMap = &configMap
+		case v.Secret != nil:
+			secret := *v.Secret
+			if secret.SecretName == "" {
+				secret.SecretName = appName
+			}
+			v.Secret = &secret
+		case v.Projected != nil:
+			projected := *v.Projected
+			projected.Sources = nil
+			for _, source := range v.Projected.Sources {
+				if source.ConfigMap != nil && source.ConfigMap.Name == "" {
+					configMap := *source.ConfigMap
+					configMap.Name = appName
+					source.ConfigMap = &configMap
+				}
+				if source.Secret != nil && source.Secret.Name == "" {
+					secret := *source.Secret
+					secret.Name = appName
+					source.Secret = &secret
+				}
+				projected.Sources = append(projected.Sources, source)
+			}
+			v.Projected = &projected
+		case v.PersistentVolumeClaim == nil && v.EmptyDir == nil:
+			v.EmptyDir = &EmptyDirVolume{}
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes
+}
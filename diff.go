@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Lines of context around each change in the unified diff
+const diffContext = 3
+
+var diffMode bool
+
+// Generated manifests for one output directory
+type diffTarget struct {
+	dir       string
+	manifests []interface{}
+}
+
+// The directories the flat layout would write to, with their manifests
+func diffTargets() ([]diffTarget, error) {
+	if render && outputDir == "" {
+		return nil, fmt.Errorf("--diff compares against an output directory; use --output-dir instead of rendering to stdout")
+	}
+
+	// Same precedence as the output modes in run()
+	targets := []environmentTarget{singleEnvironmentTarget(env)}
+	if !render && multiEnvironment() {
+		targets = environmentTargets()
+	}
+
+	var result []diffTarget
+	for _, target := range targets {
+		dir := appName
+		switch {
+		case render:
+			dir = outputDir
+		case multiEnvironment():
+			dir = filepath.Join(appName, target.name)
+		}
+		manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate manifests for %s: %w", target.name, err)
+		}
+		result = append(result, diffTarget{dir: dir, manifests: manifests})
+	}
+	return result, nil
+}
+
+// Compare the generated manifests with the files on disk and print a unified
+// diff per resource. Returns an error if anything differs.
+func runDiff(cmd *cobra.Command) error {
+	targets, err := diffTargets()
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+	for _, target := range targets {
+		generated := map[string]bool{}
+		for _, manifest := range target.manifests {
+			filename := manifestFileName(manifest)
+			generated[filename] = true
+			filePath := filepath.Join(target.dir, filename)
+
+			want, err := toObject(manifest)
+			if err != nil {
+				return err
+			}
+			have, err := readManifestObject(filePath)
+			if err != nil {
+				return err
+			}
+			maskEncrypted(want)
+			maskEncrypted(have)
+			if reflect.DeepEqual(have, want) {
+				continue
+			}
+
+			changed++
+			fromName := filePath + " (on disk)"
+			if have == nil {
+				fromName = "/dev/null"
+			}
+			fmt.Print(colorDiff(unifiedDiff(fromName, filePath+" (generated)", canonicalYAML(have), canonicalYAML(want))))
+		}
+
+		// Files on disk that would no longer be generated
+		entries, err := os.ReadDir(target.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", target.dir, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), manifestExtension()) || generated[entry.Name()] {
+				continue
+			}
+			filePath := filepath.Join(target.dir, entry.Name())
+			have, err := readManifestObject(filePath)
+			if err != nil {
+				return err
+			}
+			changed++
+			fmt.Print(colorDiff(unifiedDiff(filePath+" (on disk)", "/dev/null", canonicalYAML(have), nil)))
+		}
+	}
+
+	if changed == 0 {
+		pterm.Success.Println("No differences: the manifests on disk match the configuration")
+		return nil
+	}
+
+	// Drift is an expected outcome, not a usage error
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+	return fmt.Errorf("%d resource(s) differ from the generated manifests", changed)
+}
+
+// Read a manifest file as a generic object; a missing file reads as nil
+func readManifestObject(filePath string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+	return object, nil
+}
+
+// Encryption is randomized, so sops-encrypted Secrets and SealedSecrets are
+// only compared by their keys and metadata
+func maskEncrypted(object map[string]interface{}) {
+	var values []map[string]interface{}
+	if _, ok := object["sops"]; ok {
+		delete(object, "sops")
+		data, _ := object["data"].(map[string]interface{})
+		stringData, _ := object["stringData"].(map[string]interface{})
+		values = append(values, data, stringData)
+	}
+	if object["kind"] == "SealedSecret" {
+		spec, _ := object["spec"].(map[string]interface{})
+		encryptedData, _ := spec["encryptedData"].(map[string]interface{})
+		values = append(values, encryptedData)
+	}
+	for _, m := range values {
+		for key := range m {
+			m[key] = "(encrypted)"
+		}
+	}
+}
+
+// YAML lines with sorted keys, so key order never shows up as a change
+func canonicalYAML(object map[string]interface{}) []string {
+	if object == nil {
+		return nil
+	}
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Line diff based on the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// Unified diff with diffContext lines of context around each hunk
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	ops := diffLines(a, b)
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for c := 0; c < len(changes); {
+		start := changes[c] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[c] + diffContext + 1
+		// Merge changes whose context overlaps into one hunk
+		for c++; c < len(changes) && changes[c]-diffContext <= end; c++ {
+			end = changes[c] + diffContext + 1
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		// Line numbers at the start of the hunk
+		aLine, bLine := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+	}
+	return out.String()
+}
+
+// Color a unified diff when it goes to a terminal; redirected output and
+// NO_COLOR get the plain diff
+func colorDiff(diff string) string {
+	if !diffColor() {
+		return diff
+	}
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++"):
+			lines[i] = pterm.Bold.Sprint(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = pterm.FgCyan.Sprint(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = pterm.FgRed.Sprint(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = pterm.FgGreen.Sprint(line)
+		}
+	}
+	if diff == "" {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Whether stdout is a terminal and colors aren't turned off
+func diffColor() bool {
+	if os.Getenv("NO_COLOR") != "" || !pterm.PrintColor {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
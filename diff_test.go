@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func numberedLines(from, to int) []string {
+	var lines []string
+	for i := from; i <= to; i++ {
+		lines = append(lines, strconv.Itoa(i))
+	}
+	return lines
+}
+
+func replaceLines(lines []string, replacements map[int]string) []string {
+	result := append([]string(nil), lines...)
+	for i, line := range replacements {
+		result[i-1] = line
+	}
+	return result
+}
+
+// The hunks must match what diff -U3 prints for the same input
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{
+			name: "identical",
+			a:    numberedLines(1, 10),
+			b:    numberedLines(1, 10),
+			want: "",
+		},
+		{
+			name: "one change with context",
+			a:    numberedLines(1, 10),
+			b:    replaceLines(numberedLines(1, 10), map[int]string{5: "five"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate hunks",
+			a:    numberedLines(1, 20),
+			b:    replaceLines(numberedLines(1, 20), map[int]string{2: "two", 18: "eighteen"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			name: "overlapping context merges hunks",
+			a:    numberedLines(1, 10),
+			b:    replaceLines(numberedLines(1, 10), map[int]string{4: "x", 8: "y"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -1,10 +1,10 @@\n 1\n 2\n 3\n-4\n+x\n 5\n 6\n 7\n-8\n+y\n 9\n 10\n",
+		},
+		{
+			name: "created file",
+			a:    nil,
+			b:    []string{"a", "b"},
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "removed file",
+			a:    []string{"a", "b"},
+			b:    nil,
+			want: "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("a", "b", tt.a, tt.b)
+			if got != tt.want {
+				t.Errorf("unifiedDiff:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesKeepsCommonLines(t *testing.T) {
+	a := strings.Split("apiVersion: v1|kind: Service|metadata:|    name: web|spec:", "|")
+	b := strings.Split("apiVersion: v1|kind: Service|metadata:|    name: web|    namespace: prod|spec:", "|")
+
+	var common, added, removed int
+	for _, op := range diffLines(a, b) {
+		switch op.kind {
+		case ' ':
+			common++
+		case '+':
+			added++
+			if op.line != "    namespace: prod" {
+				t.Errorf("unexpected added line %q", op.line)
+			}
+		case '-':
+			removed++
+		}
+	}
+	if common != len(a) || added != 1 || removed != 0 {
+		t.Errorf("got %d common, %d added, %d removed lines; want %d, 1, 0", common, added, removed, len(a))
+	}
+}
+
+func TestColorDiffRespectsNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	diff := unifiedDiff("a", "b", []string{"x"}, []string{"y"})
+	if got := colorDiff(diff); got != diff {
+		t.Errorf("colorDiff with NO_COLOR added escapes: %q", got)
+	}
+}
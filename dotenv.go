@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var dotenvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Read a dotenv file into environment variables, keeping file order
+func loadEnvFile(path string, secret bool) ([]EnvVarConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	vars, err := parseDotenv(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse env file %s: %w", path, err)
+	}
+	for i := range vars {
+		vars[i].Secret = secret
+	}
+	return vars, nil
+}
+
+// Parse dotenv content. Supports comments, blank lines, an optional
+// "export" prefix, single-quoted (literal) and double-quoted (escaped)
+// values, and quoted values spanning multiple lines. Later keys win.
+func parseDotenv(content string) ([]EnvVarConfig, error) {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+
+	var vars []EnvVarConfig
+	index := map[string]int{}
+
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		// Only leading whitespace is trimmed: a quoted value starting on this
+		// line may continue on the next one, trailing spaces included
+		line := strings.TrimLeft(lines[i], " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNum)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !dotenvKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNum, key)
+		}
+		rest := strings.TrimLeft(line[eq+1:], " \t")
+
+		var value string
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			quote := rest[0]
+			body := rest[1:]
+			// Quoted values may continue over the following lines
+			end := closingQuote(body, quote)
+			for end < 0 && i+1 < len(lines) {
+				i++
+				body += "\n" + lines[i]
+				end = closingQuote(body, quote)
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value for %s", lineNum, key)
+			}
+
+			trailing := strings.TrimSpace(body[end+1:])
+			if trailing != "" && !strings.HasPrefix(trailing, "#") {
+				return nil, fmt.Errorf("line %d: unexpected characters after quoted value for %s", lineNum, key)
+			}
+
+			value = body[:end]
+			if quote == '"' {
+				value = unescapeDotenv(value)
+			}
+		} else {
+			// Unquoted values end at an inline comment
+			if idx := strings.Index(rest, " #"); idx >= 0 {
+				rest = rest[:idx]
+			} else if idx := strings.Index(rest, "\t#"); idx >= 0 {
+				rest = rest[:idx]
+			}
+			value = strings.TrimSpace(rest)
+		}
+
+		if pos, ok := index[key]; ok {
+			vars[pos].Value = value
+			continue
+		}
+		index[key] = len(vars)
+		vars = append(vars, EnvVarConfig{Name: key, Value: value})
+	}
+
+	return vars, nil
+}
+
+// Find the closing quote, skipping backslash-escaped quotes in double-quoted values
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
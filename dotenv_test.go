@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    [][2]string
+		wantErr string
+	}{
+		{
+			name:    "unquoted values",
+			content: "A=1\nB = two words \nC=x=y\n",
+			want:    [][2]string{{"A", "1"}, {"B", "two words"}, {"C", "x=y"}},
+		},
+		{
+			name:    "comments and blank lines",
+			content: "# comment\n\n  # indented comment\nA=1 # inline\nB=2\t# tab\nC=a#b\n",
+			want:    [][2]string{{"A", "1"}, {"B", "2"}, {"C", "a#b"}},
+		},
+		{
+			name:    "single-quoted values are literal",
+			content: `A='  spaced  '` + "\n" + `B='no \n escape'` + "\n" + `C='# not a comment' # comment` + "\n",
+			want:    [][2]string{{"A", "  spaced  "}, {"B", `no \n escape`}, {"C", "# not a comment"}},
+		},
+		{
+			name:    "double-quoted values are escaped",
+			content: `A="line1\nline2"` + "\n" + `B="tab\there \"quoted\" \$HOME \\ \x"` + "\n" + `C="# not a comment"` + "\n",
+			want:    [][2]string{{"A", "line1\nline2"}, {"B", "tab\there \"quoted\" $HOME \\ \\x"}, {"C", "# not a comment"}},
+		},
+		{
+			name:    "quoted values over several lines",
+			content: "A=\"first\nsecond  \"\nB='-----BEGIN KEY-----\nabc\n-----END KEY-----'\nC=3\n",
+			want:    [][2]string{{"A", "first\nsecond  "}, {"B", "-----BEGIN KEY-----\nabc\n-----END KEY-----"}, {"C", "3"}},
+		},
+		{
+			name:    "export prefix",
+			content: "export A=1\nexport\tB=\"2\"\nexport C=\nexport_D=4\n",
+			want:    [][2]string{{"A", "1"}, {"B", "2"}, {"C", ""}, {"export_D", "4"}},
+		},
+		{
+			name:    "empty values",
+			content: "A=\nB=\"\"\nC=''\n",
+			want:    [][2]string{{"A", ""}, {"B", ""}, {"C", ""}},
+		},
+		{
+			name:    "later duplicates win in the first position",
+			content: "A=1\nB=2\nA=3\n",
+			want:    [][2]string{{"A", "3"}, {"B", "2"}},
+		},
+		{
+			name:    "windows line endings",
+			content: "A=1\r\nB=\"2\"\r\n",
+			want:    [][2]string{{"A", "1"}, {"B", "2"}},
+		},
+		{
+			name:    "missing equals sign",
+			content: "A=1\nB\n",
+			wantErr: "line 2: expected KEY=VALUE",
+		},
+		{
+			name:    "invalid key",
+			content: "1A=1\n",
+			wantErr: `line 1: invalid key "1A"`,
+		},
+		{
+			name:    "unterminated quote",
+			content: "A=1\nB=\"open\nC=3\n",
+			wantErr: "line 2: unterminated quoted value for B",
+		},
+		{
+			name:    "text after a quoted value",
+			content: "A=\"1\" 2\n",
+			wantErr: "line 1: unexpected characters after quoted value for A",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := parseDotenv(tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][2]string
+			for _, v := range vars {
+				got = append(got, [2]string{v.Name, v.Value})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvironmentConfig defines a named environment. Anything it leaves empty
+// falls back to the app-wide value; the namespace defaults to <app>-<env>.
+type EnvironmentConfig struct {
+	ImageTag         string           `yaml:"imageTag"`
+	Namespace        string           `yaml:"namespace"`
+	IngressHost      string           `yaml:"ingressHost"`
+	IngressTLSSecret string           `yaml:"ingressTLSSecret"`
+	Replicas         *int             `yaml:"replicas"`
+	Resources        ResourcesSection `yaml:"resources"`
+	Env              []EnvVarConfig   `yaml:"env"`
+	EnvFiles         EnvFilesSection  `yaml:"envFiles"`
+	PDB              PDBSection       `yaml:"pdb"`
+}
+
+var (
+	environmentFlags     []string
+	selectedEnvironments []string
+	environmentConfigs   map[string]EnvironmentConfig
+	environmentOrder     []string
+)
+
+// Environments from the config file with the flags merged on top, and the
+// names they are generated in
+var (
+	resolvedEnvironments map[string]EnvironmentConfig
+	environmentNames     []string
+)
+
+// App-wide flags given on the command line; with --env they override the
+// values of the selected environment
+var environmentOverrides map[string]bool
+
+// Environments generated when neither the config file nor --environment-config
+// defines any
+var defaultEnvironments = []string{"staging", "production"}
+
+// Merge the environments from the config file, --environment-config and the
+// deprecated -stage/-prod flags, then check them and the selection.
+// Environments are generated in the order they are declared: the config
+// file's first, then new ones from --environment-config.
+func resolveEnvironments(changed func(name string) bool) error {
+	resolvedEnvironments = map[string]EnvironmentConfig{}
+	environmentNames = nil
+	define := func(name string, apply func(e *EnvironmentConfig)) {
+		e, ok := resolvedEnvironments[name]
+		if !ok {
+			environmentNames = append(environmentNames, name)
+		}
+		apply(&e)
+		resolvedEnvironments[name] = e
+	}
+
+	for _, name := range environmentOrder {
+		define(name, func(e *EnvironmentConfig) { *e = environmentConfigs[name] })
+	}
+	if len(environmentOrder) == 0 && len(environmentFlags) == 0 {
+		for _, name := range defaultEnvironments {
+			define(name, func(e *EnvironmentConfig) {})
+		}
+	}
+
+	for _, spec := range environmentFlags {
+		if err := parseEnvironmentConfig(spec, define); err != nil {
+			return fmt.Errorf("invalid --environment-config %q: %w", spec, err)
+		}
+	}
+
+	// The shorthands come last, so they only add staging or production after
+	// the declared environments when those don't define them
+	legacy := []struct {
+		name                                          string
+		imageTag, ingressHost, tlsSecret              string
+		plain, secret, configEnvFiles, secretEnvFiles []string
+	}{
+		{"staging", imageTagStage, ingressHostStage, ingressTLSSecretStage, envVarStageFlags, secretEnvVarStageFlags, configEnvFilesStage, secretEnvFilesStage},
+		{"production", imageTagProd, ingressHostProd, ingressTLSSecretProd, envVarProdFlags, secretEnvVarProdFlags, configEnvFilesProd, secretEnvFilesProd},
+	}
+	for _, l := range legacy {
+		if l.imageTag == "" && l.ingressHost == "" && l.tlsSecret == "" &&
+			len(l.plain) == 0 && len(l.secret) == 0 && len(l.configEnvFiles) == 0 && len(l.secretEnvFiles) == 0 {
+			continue
+		}
+		// Env vars and files are merged in resolveEnvVars
+		define(l.name, func(e *EnvironmentConfig) {
+			if l.imageTag != "" {
+				e.ImageTag = l.imageTag
+			}
+			if l.ingressHost != "" {
+				e.IngressHost = l.ingressHost
+			}
+			if l.tlsSecret != "" {
+				e.IngressTLSSecret = l.tlsSecret
+			}
+		})
+	}
+
+	for _, name := range environmentNames {
+		e := resolvedEnvironments[name]
+		if !dns1123LabelPattern.MatchString(name) {
+			return fmt.Errorf("environment %q: name must be a lowercase DNS label", name)
+		}
+		if e.Namespace != "" && !dns1123LabelPattern.MatchString(e.Namespace) {
+			return fmt.Errorf("environment %s: namespace %q must be a lowercase DNS label", name, e.Namespace)
+		}
+		if e.Replicas != nil && *e.Replicas < 0 {
+			return fmt.Errorf("environment %s: replicas must not be negative", name)
+		}
+		if err := checkPDBSection(e.PDB); err != nil {
+			return fmt.Errorf("environment %s: %w", name, err)
+		}
+	}
+
+	for _, name := range selectedEnvironments {
+		if _, ok := resolvedEnvironments[name]; !ok {
+			return fmt.Errorf("unknown environment %q in --environments or selectedEnvironments (defined: %s)", name, strings.Join(environmentNames, ", "))
+		}
+	}
+
+	environmentOverrides = map[string]bool{}
+	for _, flag := range []string{"image-tag", "namespace", "ingress-host", "ingress-tls-secret", "replicas",
+		"resources-requests-cpu", "resources-requests-memory", "resources-limits-cpu", "resources-limits-memory",
+		"pdb-enabled", "pdb-min-available", "pdb-max-unavailable"} {
+		environmentOverrides[flag] = changed(flag)
+	}
+	return nil
+}
+
+// Parse NAME[,imageTag=TAG][,namespace=NS][,ingressHost=HOST][,ingressTLSSecret=SECRET][,replicas=N][,resources.requests.cpu=CPU...][,pdb.enabled=BOOL...]
+// into the environment it defines or overrides
+func parseEnvironmentConfig(spec string, define func(string, func(*EnvironmentConfig))) error {
+	parts := strings.Split(spec, ",")
+	name := parts[0]
+	if !dns1123LabelPattern.MatchString(name) {
+		return fmt.Errorf("environment name must be a lowercase DNS label")
+	}
+	var override EnvironmentConfig
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return fmt.Errorf("expected key=value, got %q", part)
+		}
+		switch kv[0] {
+		case "imageTag":
+			override.ImageTag = kv[1]
+		case "namespace":
+			override.Namespace = kv[1]
+		case "ingressHost":
+			override.IngressHost = kv[1]
+		case "ingressTLSSecret":
+			override.IngressTLSSecret = kv[1]
+		case "replicas":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return fmt.Errorf("replicas must be a number, got %q", kv[1])
+			}
+			override.Replicas = &n
+		case "resources.requests.cpu":
+			override.Resources.Requests.CPU = kv[1]
+		case "resources.requests.memory":
+			override.Resources.Requests.Memory = kv[1]
+		case "resources.limits.cpu":
+			override.Resources.Limits.CPU = kv[1]
+		case "resources.limits.memory":
+			override.Resources.Limits.Memory = kv[1]
+		case "pdb.enabled":
+			enabled, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return fmt.Errorf("pdb.enabled must be true or false, got %q", kv[1])
+			}
+			override.PDB.Enabled = &enabled
+		case "pdb.minAvailable":
+			override.PDB.MinAvailable = kv[1]
+		case "pdb.maxUnavailable":
+			override.PDB.MaxUnavailable = kv[1]
+		default:
+			return fmt.Errorf("unknown key %q (expected imageTag, namespace, ingressHost, ingressTLSSecret, replicas, resources.{requests,limits}.{cpu,memory} or pdb.{enabled,minAvailable,maxUnavailable})", kv[0])
+		}
+	}
+
+	define(name, func(e *EnvironmentConfig) {
+		if override.ImageTag != "" {
+			e.ImageTag = override.ImageTag
+		}
+		if override.Namespace != "" {
+			e.Namespace = override.Namespace
+		}
+		if override.IngressHost != "" {
+			e.IngressHost = override.IngressHost
+		}
+		if override.IngressTLSSecret != "" {
+			e.IngressTLSSecret = override.IngressTLSSecret
+		}
+		if override.Replicas != nil {
+			e.Replicas = override.Replicas
+		}
+		e.Resources = mergeResources(override.Resources, e.Resources)
+		if override.PDB.Enabled != nil {
+			e.PDB.Enabled = override.PDB.Enabled
+		}
+		// A budget given on the command line replaces the declared one
+		if override.PDB.MinAvailable != "" || override.PDB.MaxUnavailable != "" {
+			e.PDB.MinAvailable = override.PDB.MinAvailable
+			e.PDB.MaxUnavailable = override.PDB.MaxUnavailable
+		}
+	})
+	return nil
+}
+
+// Names of the environments in the order the config file declares them
+func configEnvironmentOrder(data []byte) []string {
+	var doc struct {
+		Environments yaml.Node `yaml:"environments"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	var names []string
+	for i := 0; i+1 < len(doc.Environments.Content); i += 2 {
+		names = append(names, doc.Environments.Content[i].Value)
+	}
+	return names
+}
+
+// Names of the environments in a config without a recorded order
+func sortedEnvironmentNames(environments map[string]EnvironmentConfig) []string {
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Whether every defined environment, or a selection of them, is generated
+// into a directory each. --environments narrows --all-environments, and both
+// win over --env except with --render.
+func multiEnvironment() bool {
+	return allEnvironments || len(selectedEnvironments) > 0
+}
+
+// Whether an environment name can be used in per-environment settings
+func knownEnvironment(name string) bool {
+	_, ok := resolvedEnvironments[name]
+	return ok || name == env
+}
+
+// The environment as it applies to this run: with --env, app-wide flags given
+// on the command line win over the environment's own values
+func environmentConfigFor(envName string) EnvironmentConfig {
+	e := resolvedEnvironments[envName]
+	if multiEnvironment() || envName != env {
+		return e
+	}
+	if environmentOverrides["image-tag"] {
+		e.ImageTag = ""
+	}
+	if environmentOverrides["namespace"] {
+		e.Namespace = ""
+	}
+	if environmentOverrides["ingress-host"] {
+		e.IngressHost = ""
+	}
+	if environmentOverrides["ingress-tls-secret"] {
+		e.IngressTLSSecret = ""
+	}
+	if environmentOverrides["replicas"] {
+		e.Replicas = nil
+	}
+	if environmentOverrides["resources-requests-cpu"] {
+		e.Resources.Requests.CPU = ""
+	}
+	if environmentOverrides["resources-requests-memory"] {
+		e.Resources.Requests.Memory = ""
+	}
+	if environmentOverrides["resources-limits-cpu"] {
+		e.Resources.Limits.CPU = ""
+	}
+	if environmentOverrides["resources-limits-memory"] {
+		e.Resources.Limits.Memory = ""
+	}
+	if environmentOverrides["pdb-enabled"] {
+		e.PDB.Enabled = nil
+	}
+	if environmentOverrides["pdb-min-available"] || environmentOverrides["pdb-max-unavailable"] {
+		e.PDB.MinAvailable, e.PDB.MaxUnavailable = "", ""
+	}
+	return e
+}
+
+// Per-environment values used to generate one set of manifests
+type environmentTarget struct {
+	name        string
+	imageTag    string
+	namespace   string
+	ingressHost string
+	tlsSecret   string
+}
+
+// Targets for --all-environments and --environments, in the order they are
+// defined whatever the order of --environments
+func environmentTargets() []environmentTarget {
+	var names []string
+	for _, name := range environmentNames {
+		if len(selectedEnvironments) == 0 || containsString(selectedEnvironments, name) {
+			names = append(names, name)
+		}
+	}
+
+	var targets []environmentTarget
+	for _, name := range names {
+		e := environmentConfigFor(name)
+		target := environmentTarget{
+			name:        name,
+			imageTag:    e.ImageTag,
+			namespace:   e.Namespace,
+			ingressHost: e.IngressHost,
+			tlsSecret:   e.IngressTLSSecret,
+		}
+		if target.imageTag == "" {
+			target.imageTag = imageTag
+		}
+		if target.namespace == "" {
+			target.namespace = fmt.Sprintf("%s-%s", appName, name)
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// Target for a single environment, using the environment-specific namespace if none was given
+func singleEnvironmentTarget(envName string) environmentTarget {
+	e := environmentConfigFor(envName)
+	pick := func(value, fallback string) string {
+		if value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	ns := pick(e.Namespace, namespace)
+	if ns == "" && envName != "" {
+		ns = fmt.Sprintf("%s-%s", appName, envName)
+	}
+	return environmentTarget{
+		name:        envName,
+		imageTag:    pick(e.ImageTag, imageTag),
+		namespace:   ns,
+		ingressHost: pick(e.IngressHost, ingressHost),
+		tlsSecret:   pick(e.IngressTLSSecret, ingressTLSSecret),
+	}
+}
+
+// Components of an environment: its replicas and resources replace the
+// app-wide ones, while values declared on a component itself still win
+func componentsFor(envName string) []ComponentConfig {
+	e := environmentConfigFor(envName)
+	if e.Replicas == nil && e.Resources == (ResourcesSection{}) {
+		return resolvedComponents
+	}
+
+	components := make([]ComponentConfig, len(resolvedComponents))
+	for i, c := range resolvedComponents {
+		var declared ComponentConfig
+		if len(componentConfigs) > 0 {
+			declared = componentConfigs[i]
+		}
+		if e.Replicas != nil && declared.Replicas == nil {
+			c.Replicas = e.Replicas
+		}
+		c.Resources = mergeResources(declared.Resources, environmentResources(envName))
+		components[i] = c
+	}
+	return components
+}
+
+// App-wide resources with the environment's values on top
+func environmentResources(envName string) ResourcesSection {
+	return mergeResources(environmentConfigFor(envName).Resources, appResources())
+}
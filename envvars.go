@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EnvVar is a container environment variable
+type EnvVar struct {
+	Name      string        `yaml:"name"`
+	Value     string        `yaml:"value,omitempty"`
+	ValueFrom *EnvVarSource `yaml:"valueFrom,omitempty"`
+}
+
+type EnvVarSource struct {
+	FieldRef         *ObjectFieldSelector   `yaml:"fieldRef,omitempty"`
+	ResourceFieldRef *ResourceFieldSelector `yaml:"resourceFieldRef,omitempty"`
+	ConfigMapKeyRef  *KeySelector           `yaml:"configMapKeyRef,omitempty"`
+	SecretKeyRef     *KeySelector           `yaml:"secretKeyRef,omitempty"`
+}
+
+type ObjectFieldSelector struct {
+	APIVersion string `yaml:"apiVersion,omitempty"`
+	FieldPath  string `yaml:"fieldPath"`
+}
+
+type ResourceFieldSelector struct {
+	ContainerName string `yaml:"containerName,omitempty"`
+	Resource      string `yaml:"resource"`
+	Divisor       string `yaml:"divisor,omitempty"`
+}
+
+type KeySelector struct {
+	Name     string `yaml:"name"`
+	Key      string `yaml:"key"`
+	Optional *bool  `yaml:"optional,omitempty"`
+}
+
+// EnvVarConfig is an environment variable as declared in flags or the config file.
+// Plain values go into the ConfigMap, secret values into the Secret and
+// valueFrom references directly into the container env.
+type EnvVarConfig struct {
+	Name      string        `yaml:"name"`
+	Value     string        `yaml:"value,omitempty"`
+	Secret    bool          `yaml:"secret,omitempty"`
+	ValueFrom *EnvVarSource `yaml:"valueFrom,omitempty"`
+}
+
+// ConfigMap and Secret keys must be valid environment variable names
+var envVarNamePattern = regexp.MustCompile(`^[-._a-zA-Z][-._a-zA-Z0-9]*$`)
+
+// Environment variables resolved from flags and config, keyed by environment
+// name ("" holds the variables shared by all environments)
+var resolvedEnvVars map[string][]EnvVarConfig
+
+// Merge environment variables from env files, the config file and flags.
+// Config entries override env file values and flags override both.
+func resolveEnvVars() error {
+	resolvedEnvVars = map[string][]EnvVarConfig{}
+
+	// Values of the generic flags with an ENV: prefix apply to one environment
+	plainSpecs, err := scopeSpecs("env-var", envVarFlags, true)
+	if err != nil {
+		return err
+	}
+	secretSpecs, err := scopeSpecs("secret-env-var", secretEnvVarFlags, true)
+	if err != nil {
+		return err
+	}
+	configFiles, err := scopeSpecs("config-from-env-file", configEnvFiles, false)
+	if err != nil {
+		return err
+	}
+	secretFiles, err := scopeSpecs("secret-from-env-file", secretEnvFiles, false)
+	if err != nil {
+		return err
+	}
+
+	// The -stage/-prod flags are shorthands for the staging: and production: prefixes
+	legacy := []struct {
+		name, suffix                            string
+		plain, secret, configFiles, secretFiles []string
+	}{
+		{"staging", "-stage", envVarStageFlags, secretEnvVarStageFlags, configEnvFilesStage, secretEnvFilesStage},
+		{"production", "-prod", envVarProdFlags, secretEnvVarProdFlags, configEnvFilesProd, secretEnvFilesProd},
+	}
+	for _, l := range legacy {
+		plainSpecs[l.name] = append(flagValues("env-var"+l.suffix, l.plain), plainSpecs[l.name]...)
+		secretSpecs[l.name] = append(flagValues("secret-env-var"+l.suffix, l.secret), secretSpecs[l.name]...)
+		configFiles[l.name] = append(flagValues("", l.configFiles), configFiles[l.name]...)
+		secretFiles[l.name] = append(flagValues("", l.secretFiles), secretFiles[l.name]...)
+	}
+
+	type envVarSource struct {
+		envName     string
+		plain       []flagValue
+		secret      []flagValue
+		configFiles []string
+		secretFiles []string
+	}
+	// Env files given on the command line replace the ones in the config file
+	pickFiles := func(fromFlags []flagValue, fromConfig []string) []string {
+		if len(fromFlags) == 0 {
+			return fromConfig
+		}
+		var paths []string
+		for _, f := range fromFlags {
+			paths = append(paths, f.value)
+		}
+		return paths
+	}
+	sources := []envVarSource{{
+		plain:       plainSpecs[""],
+		secret:      secretSpecs[""],
+		configFiles: pickFiles(configFiles[""], envFilesConfig.Config),
+		secretFiles: pickFiles(secretFiles[""], envFilesConfig.Secret),
+	}}
+	for _, name := range environmentNames {
+		e := resolvedEnvironments[name]
+		sources = append(sources, envVarSource{
+			envName:     name,
+			plain:       plainSpecs[name],
+			secret:      secretSpecs[name],
+			configFiles: pickFiles(configFiles[name], e.EnvFiles.Config),
+			secretFiles: pickFiles(secretFiles[name], e.EnvFiles.Secret),
+		})
+	}
+
+	// Shared variables set by the user, which preset values for a single
+	// environment don't override
+	var userShared []EnvVarConfig
+	for _, src := range sources {
+		var vars []EnvVarConfig
+		for _, path := range src.configFiles {
+			fileVars, err := loadEnvFile(path, false)
+			if err != nil {
+				return err
+			}
+			vars = mergeEnvVars(vars, fileVars)
+		}
+		for _, path := range src.secretFiles {
+			fileVars, err := loadEnvFile(path, true)
+			if err != nil {
+				return err
+			}
+			vars = mergeEnvVars(vars, fileVars)
+		}
+		vars = mergeEnvVars(vars, envVarConfigs[src.envName])
+
+		var fromFlags []EnvVarConfig
+		for _, spec := range src.plain {
+			v, err := parseEnvVarSpec(spec.value)
+			if err != nil {
+				return fmt.Errorf("invalid --%s %q: %w", spec.flag, spec.value, err)
+			}
+			fromFlags = append(fromFlags, v)
+		}
+		for _, spec := range src.secret {
+			v, err := parseEnvVarSpec(spec.value)
+			if err != nil {
+				return fmt.Errorf("invalid --%s %q: %w", spec.flag, spec.value, err)
+			}
+			v.Secret = true
+			fromFlags = append(fromFlags, v)
+		}
+		if src.envName == "" {
+			for _, spec := range envVarFromFlags {
+				v, err := parseEnvVarFromSpec(spec)
+				if err != nil {
+					return fmt.Errorf("invalid --env-var-from %q: %w", spec, err)
+				}
+				fromFlags = append(fromFlags, v)
+			}
+		}
+
+		vars = mergeEnvVars(vars, fromFlags)
+
+		// Preset variables sit below everything else
+		if src.envName == "" {
+			userShared = vars
+			vars = mergeEnvVars(presetEnvVars(""), vars)
+		} else {
+			vars = mergeEnvVars(withoutEnvVars(presetEnvVars(src.envName), userShared), vars)
+		}
+		for _, v := range vars {
+			if err := validateEnvVar(v); err != nil {
+				return err
+			}
+		}
+		resolvedEnvVars[src.envName] = vars
+	}
+
+	for envName := range envVarConfigs {
+		if _, ok := resolvedEnvVars[envName]; !ok {
+			return fmt.Errorf("unsupported environment %q for env vars", envName)
+		}
+	}
+	return nil
+}
+
+// A value given with a flag
+type flagValue struct {
+	flag  string
+	value string
+}
+
+func flagValues(flag string, values []string) []flagValue {
+	var result []flagValue
+	for _, value := range values {
+		result = append(result, flagValue{flag: flag, value: value})
+	}
+	return result
+}
+
+// Group the values of a flag by the environment their ENV: prefix names, ""
+// for the ones without. KEY=VALUE specs must name a defined environment;
+// for paths the prefix only counts if it does, so paths with a colon still
+// work.
+func scopeSpecs(flag string, specs []string, keyed bool) (map[string][]flagValue, error) {
+	scoped := map[string][]flagValue{}
+	for _, spec := range specs {
+		envName, value := "", spec
+		if i := strings.Index(spec, ":"); i > 0 {
+			name := spec[:i]
+			if _, ok := resolvedEnvironments[name]; ok {
+				envName, value = name, spec[i+1:]
+			} else if keyed && !strings.Contains(name, "=") {
+				return nil, fmt.Errorf("invalid --%s %q: unknown environment %q (defined: %s)", flag, spec, name, strings.Join(environmentNames, ", "))
+			}
+		}
+		scoped[envName] = append(scoped[envName], flagValue{flag: flag, value: value})
+	}
+	return scoped, nil
+}
+
+// Environment variables for an environment: shared ones overridden by env-specific ones
+func envVarsFor(envName string) []EnvVarConfig {
+	vars := resolvedEnvVars[""]
+	if envName != "" {
+		vars = mergeEnvVars(vars, resolvedEnvVars[envName])
+	}
+	return vars
+}
+
+// Variables of vars not named in exclude
+func withoutEnvVars(vars, exclude []EnvVarConfig) []EnvVarConfig {
+	var kept []EnvVarConfig
+	for _, v := range vars {
+		excluded := false
+		for _, e := range exclude {
+			excluded = excluded || e.Name == v.Name
+		}
+		if !excluded {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// Replace variables with the same name in place and append new ones
+func mergeEnvVars(base, overrides []EnvVarConfig) []EnvVarConfig {
+	merged := append([]EnvVarConfig{}, base...)
+	for _, o := range overrides {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == o.Name {
+				merged[i] = o
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+// Split variables into ConfigMap data, Secret data and container env references
+func splitEnvVars(vars []EnvVarConfig) (configData, secretData map[string]string, containerEnv []EnvVar) {
+	configData = map[string]string{}
+	secretData = map[string]string{}
+	for _, v := range vars {
+		switch {
+		case v.ValueFrom != nil:
+			containerEnv = append(containerEnv, EnvVar{Name: v.Name, ValueFrom: v.ValueFrom})
+		case v.Secret:
+			secretData[v.Name] = v.Value
+		default:
+			configData[v.Name] = v.Value
+		}
+	}
+	return configData, secretData, containerEnv
+}
+
+// Parse KEY=VALUE
+func parseEnvVarSpec(spec string) (EnvVarConfig, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return EnvVarConfig{}, fmt.Errorf("expected KEY=VALUE")
+	}
+	return EnvVarConfig{Name: kv[0], Value: kv[1]}, nil
+}
+
+// Parse KEY=SOURCE:REF, e.g. POD_NAME=fieldRef:metadata.name or
+// DB_PASSWORD=secretKeyRef:db-credentials/password
+func parseEnvVarFromSpec(spec string) (EnvVarConfig, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return EnvVarConfig{}, fmt.Errorf("expected KEY=SOURCE:REF")
+	}
+	source := strings.SplitN(kv[1], ":", 2)
+	if len(source) != 2 || source[1] == "" {
+		return EnvVarConfig{}, fmt.Errorf("expected KEY=SOURCE:REF")
+	}
+
+	from := &EnvVarSource{}
+	switch source[0] {
+	case "fieldRef":
+		from.FieldRef = &ObjectFieldSelector{FieldPath: source[1]}
+	case "resourceFieldRef":
+		from.ResourceFieldRef = &ResourceFieldSelector{Resource: source[1]}
+	case "secretKeyRef", "configMapKeyRef":
+		ref := strings.SplitN(source[1], "/", 2)
+		if len(ref) != 2 || ref[0] == "" || ref[1] == "" {
+			return EnvVarConfig{}, fmt.Errorf("%s expects NAME/KEY", source[0])
+		}
+		selector := &KeySelector{Name: ref[0], Key: ref[1]}
+		if source[0] == "secretKeyRef" {
+			from.SecretKeyRef = selector
+		} else {
+			from.ConfigMapKeyRef = selector
+		}
+	default:
+		return EnvVarConfig{}, fmt.Errorf("unknown source %q (expected fieldRef, resourceFieldRef, secretKeyRef or configMapKeyRef)", source[0])
+	}
+
+	return EnvVarConfig{Name: kv[0], ValueFrom: from}, nil
+}
+
+func validateEnvVar(v EnvVarConfig) error {
+	if !envVarNamePattern.MatchString(v.Name) {
+		return fmt.Errorf("invalid environment variable name %q", v.Name)
+	}
+	if v.ValueFrom == nil {
+		return nil
+	}
+	if v.Secret || v.Value != "" {
+		return fmt.Errorf("environment variable %s: valueFrom cannot be combined with value or secret", v.Name)
+	}
+
+	sources := 0
+	if v.ValueFrom.FieldRef != nil {
+		sources++
+	}
+	if v.ValueFrom.ResourceFieldRef != nil {
+		sources++
+	}
+	if v.ValueFrom.ConfigMapKeyRef != nil {
+		sources++
+	}
+	if v.ValueFrom.SecretKeyRef != nil {
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("environment variable %s: valueFrom needs exactly one source", v.Name)
+	}
+	return nil
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"gopkg.in/yaml.v3"
+)
+
+// The bundled chart; _helpers.tpl is listed explicitly because embedding a
+// directory skips files starting with an underscore
+//
+//go:embed chart/k8s-config-generator chart/k8s-config-generator/templates/_helpers.tpl
+var bundledChart embed.FS
+
+const bundledChartDir = "chart/k8s-config-generator"
+
+// Write the bundled chart (unless valuesOnly) and a values-<env>.yaml per environment
+func createHelmLayout(rootDir string, valuesOnly bool) error {
+	targets, err := layoutTargets()
+	if err != nil {
+		return err
+	}
+
+	// The chart templates a single webserver Deployment
+	if len(componentConfigs) > 0 {
+		return fmt.Errorf("the bundled chart only renders the default webserver component; use --format flat or --format kustomize")
+	}
+
+	// The chart sets the recommended labels itself and has no values for others
+	if len(resolvedLabels) > 0 || len(resolvedAnnotations) > 0 {
+		return fmt.Errorf("the bundled chart cannot set custom labels or annotations; use --format flat or --format kustomize")
+	}
+	if recommendedLabels {
+		return fmt.Errorf("the bundled chart sets its own recommended labels; drop --recommended-labels or use --format flat or --format kustomize")
+	}
+
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	if !valuesOnly {
+		if err := copyBundledChart(rootDir); err != nil {
+			return err
+		}
+		pterm.Success.Printf("  ✓ chart written to %s\n", rootDir)
+	}
+
+	for _, target := range targets {
+		values, manifests, err := chartValuesFor(target)
+		if err != nil {
+			return fmt.Errorf("failed to build chart values for %s: %w", target.name, err)
+		}
+
+		data, err := yaml.Marshal(values)
+		if err != nil {
+			return fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+		if err := checkChartParity(manifests, data); err != nil {
+			return fmt.Errorf("%s: %w", target.name, err)
+		}
+		header := fmt.Sprintf("# %s values generated by kcg; install with:\n#   helm install %s <chart> -f values-%s.yaml\n", target.name, appName, target.name)
+
+		filePath := filepath.Join(rootDir, fmt.Sprintf("values-%s.yaml", target.name))
+		if err := os.WriteFile(filePath, append([]byte(header), data...), 0644); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", filePath, err)
+		}
+		pterm.Success.Printf("  ✓ %s values written to %s\n", target.name, filePath)
+	}
+
+	pterm.Print("\n")
+	pterm.Success.Printf("Helm values generated successfully!\n")
+	pterm.Info.Printf("The release name must be the app name, e.g.: helm install %s %s -f %s\n", appName, rootDir, filepath.Join(rootDir, fmt.Sprintf("values-%s.yaml", targets[0].name)))
+
+	return nil
+}
+
+// Copy the embedded chart, leaving out the per-environment example values
+func copyBundledChart(rootDir string) error {
+	return fs.WalkDir(bundledChart, bundledChartDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, bundledChartDir), "/")
+		dest := filepath.Join(rootDir, filepath.FromSlash(rel))
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if strings.HasPrefix(path.Base(p), "values-") {
+			return nil
+		}
+		data, err := bundledChart.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0644)
+	})
+}
+
+// Generate an environment and translate the manifests into values for the bundled chart
+func chartValuesFor(target environmentTarget) (map[string]interface{}, []interface{}, error) {
+	manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := chartValues(manifests, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Helm merges values files into the chart defaults, so default keys we
+	// don't set would leak into nested maps; null removes them
+	object, err := toObject(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaults, err := bundledChartDefaults()
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, value := range object {
+		if m, ok := value.(map[string]interface{}); ok && m["enabled"] != false {
+			if d, ok := defaults[key].(map[string]interface{}); ok {
+				clearDefaults(m, d)
+			}
+		}
+	}
+	return object, manifests, nil
+}
+
+// The chart's values.yaml as a generic object
+func bundledChartDefaults() (map[string]interface{}, error) {
+	data, err := bundledChart.ReadFile(bundledChartDir + "/values.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var defaults map[string]interface{}
+	if err := yaml.Unmarshal(data, &defaults); err != nil {
+		return nil, fmt.Errorf("failed to parse chart values: %w", err)
+	}
+	return defaults, nil
+}
+
+// Set keys that only exist in the defaults to null
+func clearDefaults(values, defaults map[string]interface{}) {
+	for key, d := range defaults {
+		v, ok := values[key]
+		if !ok {
+			values[key] = nil
+			continue
+		}
+		vm, vok := v.(map[string]interface{})
+		dm, dok := d.(map[string]interface{})
+		if vok && dok {
+			clearDefaults(vm, dm)
+		}
+	}
+}
+
+// Translate generated manifests into chart values. Resources the chart cannot
+// express are reported as errors rather than silently dropped.
+func chartValues(manifests []interface{}, target environmentTarget) (map[string]interface{}, error) {
+	values := map[string]interface{}{
+		"fullnameOverride": appName,
+		"namespace": map[string]interface{}{
+			"create": false,
+			"name":   target.namespace,
+		},
+		"serviceAccount": map[string]interface{}{
+			"create": false,
+		},
+		"ingress": map[string]interface{}{
+			"enabled": false,
+		},
+		"configMap": map[string]interface{}{
+			"enabled": false,
+		},
+		"secret": map[string]interface{}{
+			"enabled": false,
+		},
+	}
+
+	for _, manifest := range manifests {
+		switch m := manifest.(type) {
+		case *Namespace:
+			values["namespace"] = map[string]interface{}{
+				"create": true,
+				"name":   m.Metadata.Name,
+			}
+		case *ServiceAccount:
+			values["serviceAccount"] = map[string]interface{}{
+				"create": true,
+				"name":   m.Metadata.Name,
+			}
+		case *ConfigMap:
+			values["configMap"] = map[string]interface{}{
+				"enabled": true,
+				"data":    m.Data,
+			}
+		case *Secret:
+			secret := map[string]interface{}{"enabled": true}
+			if len(m.StringData) > 0 {
+				secret["stringData"] = m.StringData
+			} else {
+				secret["data"] = m.Data
+			}
+			values["secret"] = secret
+		case *PersistentVolumeClaim:
+			claims, _ := values["persistentVolumeClaims"].([]interface{})
+			claim := map[string]interface{}{
+				"name":        m.Metadata.Name,
+				"size":        m.Spec.Resources.Requests["storage"],
+				"accessModes": m.Spec.AccessModes,
+			}
+			if m.Spec.StorageClassName != "" {
+				claim["storageClassName"] = m.Spec.StorageClassName
+			}
+			if m.Spec.VolumeMode != "" {
+				claim["volumeMode"] = m.Spec.VolumeMode
+			}
+			values["persistentVolumeClaims"] = append(claims, claim)
+		case *SopsSecret, *SealedSecret, *ExternalSecret:
+			return nil, fmt.Errorf("the bundled chart only renders plain Secrets; use --format flat or --format kustomize with --secret-format %s", secretFormat)
+		case *Deployment:
+			if err := deploymentValues(values, m, target); err != nil {
+				return nil, err
+			}
+		case *Service:
+			if len(m.Spec.Ports) != 1 {
+				return nil, fmt.Errorf("the bundled chart supports a single Service port")
+			}
+			values["service"] = map[string]interface{}{
+				"type": m.Spec.Type,
+				"port": m.Spec.Ports[0].Port,
+			}
+		case *Ingress:
+			var hosts []interface{}
+			for _, rule := range m.Spec.Rules {
+				var paths []interface{}
+				for _, p := range rule.HTTP.Paths {
+					paths = append(paths, map[string]interface{}{"path": p.Path, "pathType": p.PathType})
+				}
+				hosts = append(hosts, map[string]interface{}{"host": rule.Host, "paths": paths})
+			}
+			var tls []interface{}
+			for _, t := range m.Spec.TLS {
+				tls = append(tls, map[string]interface{}{"secretName": t.SecretName, "hosts": t.Hosts})
+			}
+			values["ingress"] = map[string]interface{}{
+				"enabled":   true,
+				"className": m.Spec.IngressClassName,
+				"hosts":     hosts,
+				"tls":       tls,
+			}
+		case *ResourceQuota:
+			values["resourceQuota"] = map[string]interface{}{
+				"enabled": true,
+				"hard":    m.Spec.Hard,
+			}
+		case *VPA:
+			values["vpa"] = map[string]interface{}{
+				"enabled":      true,
+				"updatePolicy": m.Spec.UpdatePolicy,
+			}
+		case *HPA:
+			autoscaling := map[string]interface{}{
+				"enabled":                           true,
+				"maxReplicas":                       m.Spec.MaxReplicas,
+				"targetCPUUtilizationPercentage":    0,
+				"targetMemoryUtilizationPercentage": 0,
+			}
+			if m.Spec.MinReplicas != nil {
+				autoscaling["minReplicas"] = *m.Spec.MinReplicas
+			}
+			for _, metric := range m.Spec.Metrics {
+				switch metric.Resource.Name {
+				case "cpu":
+					autoscaling["targetCPUUtilizationPercentage"] = metric.Resource.Target.AverageUtilization
+				case "memory":
+					autoscaling["targetMemoryUtilizationPercentage"] = metric.Resource.Target.AverageUtilization
+				}
+			}
+			if m.Spec.Behavior != nil {
+				autoscaling["behavior"] = m.Spec.Behavior
+			}
+			values["autoscaling"] = autoscaling
+		case *NetworkPolicy:
+			if _, ok := values["networkPolicy"]; !ok {
+				values["networkPolicy"] = networkPolicyValues()
+			}
+		case *PatchedObject:
+			return nil, fmt.Errorf("the bundled chart cannot render patched resources (%s %s); use --format flat or --format kustomize", m.kind(), m.name())
+		case *PodDisruptionBudget:
+			pdb := map[string]interface{}{"enabled": true}
+			if m.Spec.MinAvailable != nil {
+				pdb["minAvailable"] = m.Spec.MinAvailable
+			} else {
+				pdb["maxUnavailable"] = m.Spec.MaxUnavailable
+			}
+			values["podDisruptionBudget"] = pdb
+		default:
+			kind, _ := manifestKindName(manifest)
+			return nil, fmt.Errorf("the bundled chart cannot render %s resources; use --format flat or --format kustomize", kind)
+		}
+	}
+
+	return values, nil
+}
+
+// The chart renders every NetworkPolicy from the same settings as the generator
+func networkPolicyValues() map[string]interface{} {
+	var egress []interface{}
+	for _, rule := range resolvedEgressRules {
+		ports, _ := parsePolicyPorts(rule.Ports)
+		egress = append(egress, map[string]interface{}{"name": rule.Name, "cidrs": rule.CIDRs, "ports": ports})
+	}
+	values := map[string]interface{}{
+		"enabled":            true,
+		"defaultDeny":        networkPolicyDefaultDeny,
+		"allowSameNamespace": networkPolicyAllowSameNamespace,
+		"allowDNS":           networkPolicyAllowDNS,
+		"egress":             egress,
+	}
+	if peer, err := ingressControllerPeer(); err == nil {
+		controller := map[string]interface{}{
+			"namespace": peer.NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"],
+		}
+		if peer.PodSelector != nil {
+			controller["podLabels"] = peer.PodSelector.MatchLabels
+		}
+		values["ingressController"] = controller
+	}
+	return values
+}
+
+func deploymentValues(values map[string]interface{}, d *Deployment, target environmentTarget) error {
+	pod := d.Spec.Template.Spec
+	container := pod.Containers[0]
+	if len(container.Ports) != 1 {
+		return fmt.Errorf("the bundled chart supports a single container port")
+	}
+
+	if d.Spec.Replicas != nil {
+		values["replicaCount"] = *d.Spec.Replicas
+	}
+	values["image"] = map[string]interface{}{
+		"repository": imageRepo,
+		"tag":        target.imageTag,
+		"pullPolicy": container.ImagePullPolicy,
+	}
+	values["component"] = primaryComponent().Name
+	values["containerPort"] = container.Ports[0].ContainerPort
+	values["containerPortName"] = container.Ports[0].Name
+	values["podSecurityContext"] = pod.SecurityContext
+	values["securityContext"] = container.SecurityContext
+	values["imagePullSecrets"] = pod.ImagePullSecrets
+
+	// The chart always uses the service account name from values
+	sa, _ := values["serviceAccount"].(map[string]interface{})
+	sa["name"] = pod.ServiceAccountName
+
+	values["env"] = container.Env
+	values["envFrom"] = container.EnvFrom
+	values["resources"] = container.Resources
+	values["livenessProbe"] = container.LivenessProbe
+	values["readinessProbe"] = container.ReadinessProbe
+	values["startupProbe"] = container.StartupProbe
+	values["nodeSelector"] = pod.NodeSelector
+	values["affinity"] = pod.Affinity
+	values["tolerations"] = pod.Tolerations
+	values["topologySpreadConstraints"] = pod.TopologySpreadConstraints
+	values["strategy"] = d.Spec.Strategy
+
+	// Extra containers and volumes are passed through as full specs
+	values["sidecars"] = pod.Containers[1:]
+	values["initContainers"] = pod.InitContainers
+	values["volumes"] = pod.Volumes
+	values["volumeMounts"] = container.VolumeMounts
+	return nil
+}
+
+// Render the bundled chart with the generated values and compare the result
+// with the flat manifests, so the chart and the generator cannot drift apart
+func checkChartParity(manifests []interface{}, valuesData []byte) error {
+	chartFS, err := fs.Sub(bundledChart, bundledChartDir)
+	if err != nil {
+		return err
+	}
+	chart, err := loadChart(chartFS)
+	if err != nil {
+		return err
+	}
+	values, err := parseChartValues(valuesData)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderChart(chart, appName, "default", values)
+	if err != nil {
+		return err
+	}
+
+	var objects []map[string]interface{}
+	for _, m := range rendered {
+		var object map[string]interface{}
+		if err := yaml.Unmarshal([]byte(m.content), &object); err != nil {
+			return fmt.Errorf("failed to parse rendered %s: %w", m.source, err)
+		}
+		objects = append(objects, object)
+	}
+
+	diffs, err := chartParityDiffs(manifests, objects)
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("the bundled chart does not reproduce the generated manifests:\n  %s", strings.Join(diffs, "\n  "))
+	}
+	return nil
+}
+
+// Describe where the objects rendered from a chart differ from the generated
+// manifests
+func chartParityDiffs(manifests []interface{}, rendered []map[string]interface{}) ([]string, error) {
+	renderedObjects := map[string]map[string]interface{}{}
+	var renderedKeys []string
+	for _, object := range rendered {
+		key := parityKey(object)
+		renderedObjects[key] = object
+		renderedKeys = append(renderedKeys, key)
+	}
+
+	var diffs []string
+	seen := map[string]bool{}
+	for _, manifest := range manifests {
+		object, err := toObject(manifest)
+		if err != nil {
+			return nil, err
+		}
+		key := parityKey(object)
+		seen[key] = true
+		other, ok := renderedObjects[key]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: not rendered by the chart", key))
+			continue
+		}
+		other = withoutChartLabels(other, object)
+		diffs = append(diffs, diffPaths(key, normalizeForParity(object), normalizeForParity(other), "generated", "chart")...)
+	}
+	for _, key := range renderedKeys {
+		if !seen[key] {
+			diffs = append(diffs, fmt.Sprintf("%s: only rendered by the chart", key))
+		}
+	}
+	return diffs, nil
+}
+
+func parityKey(object map[string]interface{}) string {
+	meta, _ := object["metadata"].(map[string]interface{})
+	return fmt.Sprintf("%v/%v", object["kind"], meta["name"])
+}
+
+// Labels the chart's labels helper puts on every object
+var chartCommonLabels = []string{
+	"helm.sh/chart",
+	"app.kubernetes.io/name",
+	"app.kubernetes.io/instance",
+	"app.kubernetes.io/version",
+	"app.kubernetes.io/managed-by",
+}
+
+// A rendered object without the labels the chart adds by design: its common
+// labels and the selector labels it repeats on the Deployment. Labels the
+// generated object sets itself are kept, so every other label is compared.
+func withoutChartLabels(rendered, generated map[string]interface{}) map[string]interface{} {
+	meta, _ := rendered["metadata"].(map[string]interface{})
+	labels, _ := meta["labels"].(map[string]interface{})
+	if len(labels) == 0 {
+		return rendered
+	}
+	generatedMeta, _ := generated["metadata"].(map[string]interface{})
+	generatedLabels, _ := generatedMeta["labels"].(map[string]interface{})
+
+	spec, _ := rendered["spec"].(map[string]interface{})
+	selector, _ := spec["selector"].(map[string]interface{})
+	matchLabels, _ := selector["matchLabels"].(map[string]interface{})
+
+	kept := map[string]interface{}{}
+	for key, value := range labels {
+		_, generatedHas := generatedLabels[key]
+		chartOwn := containsString(chartCommonLabels, key) || (rendered["kind"] == "Deployment" && matchLabels[key] == value)
+		if generatedHas || !chartOwn {
+			kept[key] = value
+		}
+	}
+
+	copied := map[string]interface{}{}
+	for key, value := range rendered {
+		copied[key] = value
+	}
+	copiedMeta := map[string]interface{}{}
+	for key, value := range meta {
+		copiedMeta[key] = value
+	}
+	copiedMeta["labels"] = kept
+	copied["metadata"] = copiedMeta
+	return copied
+}
+
+// Drop empty values, which the generator and the chart express differently
+func normalizeForParity(object map[string]interface{}) interface{} {
+	return pruneEmpty(object)
+}
+
+func pruneEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		pruned := map[string]interface{}{}
+		for key, item := range v {
+			if item = pruneEmpty(item); item != nil {
+				pruned[key] = item
+			}
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		pruned := make([]interface{}, len(v))
+		for i, item := range v {
+			pruned[i] = pruneEmpty(item)
+		}
+		return pruned
+	default:
+		return value
+	}
+}
+
+// Describe where two generic objects differ, naming the sides aName and bName
+func diffPaths(path string, a, b interface{}, aName, bName string) []string {
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		keys := map[string]bool{}
+		for key := range aMap {
+			keys[key] = true
+		}
+		for key := range bMap {
+			keys[key] = true
+		}
+		var sorted []string
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		var diffs []string
+		for _, key := range sorted {
+			diffs = append(diffs, diffPaths(path+"."+key, aMap[key], bMap[key], aName, bName)...)
+		}
+		return diffs
+	}
+
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if aIsList && bIsList && len(aList) == len(bList) {
+		var diffs []string
+		for i := range aList {
+			diffs = append(diffs, diffPaths(fmt.Sprintf("%s[%d]", path, i), aList[i], bList[i], aName, bName)...)
+		}
+		return diffs
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: %s %s, %s %s", path, aName, describeValue(a), bName, describeValue(b))}
+}
+
+// Short form of a value for diff messages; maps and lists as JSON
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "unset"
+	case map[string]interface{}, []interface{}:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(value)
+}
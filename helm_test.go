@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pterm/pterm"
+	"gopkg.in/yaml.v3"
+)
+
+// The chart written by --format helm, rendered with each values file, must
+// reproduce the flat manifests of that environment. The real helm is used
+// as well when it is installed.
+func TestHelmChartMatchesFlatManifests(t *testing.T) {
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	helm, err := exec.LookPath("helm")
+	if err != nil {
+		t.Log("helm not found, only rendering with the built-in renderer")
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"defaults", nil},
+		{"node preset", []string{"--preset", "node"}},
+		{"ingress and autoscaling", []string{
+			"--ingress-enabled", "--ingress-class", "nginx",
+			"--ingress-host-stage", "stage.example.com",
+			"--ingress-host-prod", "example.com", "--ingress-tls-secret-prod", "example-tls",
+			"--hpa-enabled", "--hpa-min-replicas", "2", "--hpa-max-replicas", "6", "--hpa-cpu-target", "70",
+			"--resources-requests-cpu", "100m", "--resources-requests-memory", "128Mi", "--resources-limits-memory", "256Mi",
+		}},
+		{"env vars and secrets", []string{
+			"--env-var", "LOG_FORMAT=json",
+			"--env-var", "staging:LOG_LEVEL=debug",
+			"--secret-env-var", "production:DB_PASSWORD=changeme",
+			"--env-var-from", "POD_NAME=fieldRef:metadata.name",
+			"--secret-data-encoding", "base64",
+		}},
+		{"policies", []string{
+			"--network-policy-enabled", "--pdb-enabled", "--vpa-enabled", "--resource-quota-enabled",
+			"--image-pull-secret", "registry-credentials",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := append([]string{
+				"--app-name", "myapp",
+				"--image-repo", "registry.example.com/myapp",
+				"--environment-config", "staging,imageTag=staging-123",
+				"--environment-config", "production,imageTag=production-abc123,replicas=3",
+				"--all-environments", "--format", "helm", "--output-dir", dir,
+			}, tt.args...)
+			cmd := newRootCommand()
+			cmd.SetArgs(args)
+			cmd.SetOut(io.Discard)
+			cmd.SetErr(io.Discard)
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("kcg %s: %v", strings.Join(args, " "), err)
+			}
+
+			chart, err := loadChart(os.DirFS(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, target := range environmentTargets() {
+				manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
+				if err != nil {
+					t.Fatal(err)
+				}
+				valuesFile := filepath.Join(dir, "values-"+target.name+".yaml")
+				data, err := os.ReadFile(valuesFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values, err := parseChartValues(data)
+				if err != nil {
+					t.Fatalf("%s: %v", valuesFile, err)
+				}
+
+				rendered, err := renderChart(chart, appName, target.namespace, values)
+				if err != nil {
+					t.Fatalf("%s: %v", target.name, err)
+				}
+				var stream bytes.Buffer
+				for _, m := range rendered {
+					stream.WriteString("---\n" + m.content + "\n")
+				}
+				assertChartParity(t, target.name, manifests, stream.Bytes())
+
+				if helm != "" {
+					out, err := exec.Command(helm, "template", appName, dir, "-f", valuesFile, "--namespace", target.namespace).Output()
+					if err != nil {
+						t.Fatalf("helm template for %s: %v", target.name, err)
+					}
+					assertChartParity(t, target.name+" (helm template)", manifests, out)
+				}
+			}
+		})
+	}
+}
+
+func assertChartParity(t *testing.T, name string, manifests []interface{}, stream []byte) {
+	t.Helper()
+	var objects []map[string]interface{}
+	decoder := yaml.NewDecoder(bytes.NewReader(stream))
+	for {
+		var object map[string]interface{}
+		err := decoder.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to parse the rendered chart: %v", name, err)
+		}
+		if object != nil {
+			objects = append(objects, object)
+		}
+	}
+
+	diffs, err := chartParityDiffs(manifests, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) > 0 {
+		t.Errorf("%s: the chart does not reproduce the flat manifests:\n  %s", name, strings.Join(diffs, "\n  "))
+	}
+}
+
+// Labels are compared too, apart from the ones the chart adds by design
+func TestChartParityComparesLabels(t *testing.T) {
+	chartLabels := "helm.sh/chart: k8s-config-generator-0.1.0\n      app.kubernetes.io/name: k8s-config-generator\n      app.kubernetes.io/instance: myapp\n      app.kubernetes.io/managed-by: Helm"
+	tests := []struct {
+		name      string
+		generated string
+		rendered  string
+		wantDiff  string
+	}{
+		{
+			name:      "chart labels only",
+			generated: "kind: Service\nmetadata:\n  name: myapp\n",
+			rendered:  "kind: Service\nmetadata:\n    name: myapp\n    labels:\n      " + chartLabels + "\n",
+		},
+		{
+			name:      "selector labels repeated on the Deployment",
+			generated: "kind: Deployment\nmetadata:\n  name: myapp-node\nspec:\n  selector:\n    matchLabels: {tier: webserver}\n",
+			rendered:  "kind: Deployment\nmetadata:\n    name: myapp-node\n    labels:\n      " + chartLabels + "\n      tier: webserver\nspec:\n  selector:\n    matchLabels: {tier: webserver}\n",
+		},
+		{
+			name:      "generated label missing from the chart",
+			generated: "kind: Service\nmetadata:\n  name: myapp\n  labels: {app.kubernetes.io/part-of: shop}\n",
+			rendered:  "kind: Service\nmetadata:\n    name: myapp\n    labels:\n      " + chartLabels + "\n",
+			wantDiff:  "Service/myapp.metadata.labels",
+		},
+		{
+			name:      "generated label with another value",
+			generated: "kind: Service\nmetadata:\n  name: myapp\n  labels: {app.kubernetes.io/managed-by: k8s-config-generator}\n",
+			rendered:  "kind: Service\nmetadata:\n    name: myapp\n    labels:\n      " + chartLabels + "\n",
+			wantDiff:  "Service/myapp.metadata.labels",
+		},
+		{
+			name:      "extra label in the chart",
+			generated: "kind: Service\nmetadata:\n  name: myapp\n",
+			rendered:  "kind: Service\nmetadata:\n    name: myapp\n    labels:\n      " + chartLabels + "\n      team: core\n",
+			wantDiff:  "Service/myapp.metadata.labels",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var generated, rendered map[string]interface{}
+			if err := yaml.Unmarshal([]byte(tt.generated), &generated); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.rendered), &rendered); err != nil {
+				t.Fatal(err)
+			}
+			diffs, err := chartParityDiffs([]interface{}{generated}, []map[string]interface{}{rendered})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Join(diffs, "\n")
+			if tt.wantDiff == "" && got != "" {
+				t.Errorf("unexpected differences:\n%s", got)
+			}
+			if tt.wantDiff != "" && !strings.Contains(got, tt.wantDiff) {
+				t.Errorf("got differences %q, want one at %s", got, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestHelmRejectsRecommendedLabels(t *testing.T) {
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{
+		"--app-name", "myapp", "--image-repo", "registry.example.com/myapp", "--image-tag", "v1",
+		"--env", "staging", "--format", "helm", "--output-dir", t.TempDir(), "--recommended-labels",
+	})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--recommended-labels") {
+		t.Fatalf("got error %v, want one naming --recommended-labels", err)
+	}
+}
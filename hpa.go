@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type HPA struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   Metadata `yaml:"metadata"`
+	Spec       HPASpec  `yaml:"spec"`
+}
+
+type HPASpec struct {
+	ScaleTargetRef HPATargetRef `yaml:"scaleTargetRef"`
+	MinReplicas    *int32       `yaml:"minReplicas,omitempty"`
+	MaxReplicas    int32        `yaml:"maxReplicas"`
+	Metrics        []HPAMetric  `yaml:"metrics,omitempty"`
+	Behavior       *HPABehavior `yaml:"behavior,omitempty"`
+}
+
+type HPATargetRef struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+}
+
+type HPAMetric struct {
+	Type     string            `yaml:"type"`
+	Resource HPAResourceMetric `yaml:"resource"`
+}
+
+type HPAResourceMetric struct {
+	Name   string          `yaml:"name"`
+	Target HPAMetricTarget `yaml:"target"`
+}
+
+type HPAMetricTarget struct {
+	Type               string `yaml:"type"`
+	AverageUtilization int    `yaml:"averageUtilization"`
+}
+
+type HPABehavior struct {
+	ScaleUp   *HPAScalingRules `yaml:"scaleUp,omitempty"`
+	ScaleDown *HPAScalingRules `yaml:"scaleDown,omitempty"`
+}
+
+type HPAScalingRules struct {
+	StabilizationWindowSeconds *int               `yaml:"stabilizationWindowSeconds,omitempty"`
+	SelectPolicy               string             `yaml:"selectPolicy,omitempty"`
+	Policies                   []HPAScalingPolicy `yaml:"policies,omitempty"`
+}
+
+type HPAScalingPolicy struct {
+	Type          string `yaml:"type"`
+	Value         int    `yaml:"value"`
+	PeriodSeconds int    `yaml:"periodSeconds"`
+}
+
+// Create HorizontalPodAutoscaler resource
+func createHPA(envName, ns string) (*HPA, error) {
+	if hpaMinReplicas < 1 {
+		return nil, fmt.Errorf("--hpa-min-replicas must be at least 1")
+	}
+	if hpaMaxReplicas < hpaMinReplicas {
+		return nil, fmt.Errorf("--hpa-max-replicas (%d) must not be less than --hpa-min-replicas (%d)", hpaMaxReplicas, hpaMinReplicas)
+	}
+	if hpaCPUTarget <= 0 && hpaMemoryTarget <= 0 {
+		return nil, fmt.Errorf("HPA needs a CPU or memory utilization target (--hpa-cpu-target or --hpa-memory-target)")
+	}
+
+	var metrics []HPAMetric
+	if hpaCPUTarget > 0 {
+		metrics = append(metrics, utilizationMetric("cpu", hpaCPUTarget))
+	}
+	if hpaMemoryTarget > 0 {
+		metrics = append(metrics, utilizationMetric("memory", hpaMemoryTarget))
+
+		// Utilization is relative to the requests, which default to the limits
+		resources := componentsFor(envName)[0].Resources
+		if resources.Requests.Memory == "" && resources.Limits.Memory == "" {
+			warnf("HPA memory target has no effect in %s: %s has no memory requests (set --resources-requests-memory, or --hpa-memory-target 0 to scale on CPU only)", environmentLabel(envName), primaryComponent().deploymentName())
+		}
+	}
+
+	behavior, err := hpaBehavior()
+	if err != nil {
+		return nil, err
+	}
+
+	minReplicas := int32(hpaMinReplicas)
+
+	return &HPA{
+		APIVersion: "autoscaling/v2",
+		Kind:       "HorizontalPodAutoscaler",
+		Metadata: Metadata{
+			Name:      fmt.Sprintf("%s-hpa", appName),
+			Namespace: ns,
+		},
+		Spec: HPASpec{
+			ScaleTargetRef: HPATargetRef{
+				APIVersion: "apps/v1",
+				Kind:       primaryComponent().workloadKind(),
+				Name:       primaryComponent().deploymentName(),
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: int32(hpaMaxReplicas),
+			Metrics:     metrics,
+			Behavior:    behavior,
+		},
+	}, nil
+}
+
+func utilizationMetric(resource string, target int) HPAMetric {
+	return HPAMetric{
+		Type: "Resource",
+		Resource: HPAResourceMetric{
+			Name: resource,
+			Target: HPAMetricTarget{
+				Type:               "Utilization",
+				AverageUtilization: target,
+			},
+		},
+	}
+}
+
+// Build scaling behavior from the config file and the scale-up/scale-down flags
+func hpaBehavior() (*HPABehavior, error) {
+	scaleUp, err := scalingRules("scale-up", hpaScaleUpStabilization, hpaScaleUpSelectPolicy, hpaScaleUpPolicies, hpaBehaviorConfig.ScaleUp)
+	if err != nil {
+		return nil, err
+	}
+	scaleDown, err := scalingRules("scale-down", hpaScaleDownStabilization, hpaScaleDownSelectPolicy, hpaScaleDownPolicies, hpaBehaviorConfig.ScaleDown)
+	if err != nil {
+		return nil, err
+	}
+
+	if scaleUp == nil && scaleDown == nil {
+		return nil, nil
+	}
+	return &HPABehavior{ScaleUp: scaleUp, ScaleDown: scaleDown}, nil
+}
+
+// Merge flag values over the rules from the config file (if any)
+func scalingRules(direction string, stabilization int, selectPolicy string, policies []string, base *HPAScalingRules) (*HPAScalingRules, error) {
+	rules := HPAScalingRules{}
+	if base != nil {
+		rules = *base
+	}
+
+	if stabilization >= 0 {
+		window := stabilization
+		rules.StabilizationWindowSeconds = &window
+	}
+	if selectPolicy != "" {
+		rules.SelectPolicy = selectPolicy
+	}
+	if len(policies) > 0 {
+		rules.Policies = nil
+		for _, p := range policies {
+			policy, err := parseScalingPolicy(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --hpa-%s-policy %q: %w", direction, p, err)
+			}
+			rules.Policies = append(rules.Policies, policy)
+		}
+	}
+
+	switch rules.SelectPolicy {
+	case "", "Max", "Min", "Disabled":
+	default:
+		return nil, fmt.Errorf("invalid HPA %s select policy %q (expected Max, Min or Disabled)", direction, rules.SelectPolicy)
+	}
+	for _, policy := range rules.Policies {
+		if policy.Type != "Pods" && policy.Type != "Percent" {
+			return nil, fmt.Errorf("invalid HPA %s policy type %q (expected Pods or Percent)", direction, policy.Type)
+		}
+		if policy.Value <= 0 || policy.PeriodSeconds <= 0 || policy.PeriodSeconds > 1800 {
+			return nil, fmt.Errorf("invalid HPA %s policy %s: value must be positive and periodSeconds between 1 and 1800", direction, policy.Type)
+		}
+	}
+
+	if rules.StabilizationWindowSeconds == nil && rules.SelectPolicy == "" && len(rules.Policies) == 0 {
+		return nil, nil
+	}
+	return &rules, nil
+}
+
+// Parse a TYPE:VALUE:PERIOD_SECONDS policy, e.g. Percent:100:15 or Pods:4:60
+func parseScalingPolicy(spec string) (HPAScalingPolicy, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return HPAScalingPolicy{}, fmt.Errorf("expected TYPE:VALUE:PERIOD_SECONDS")
+	}
+	value, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return HPAScalingPolicy{}, fmt.Errorf("invalid value: %w", err)
+	}
+	period, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return HPAScalingPolicy{}, fmt.Errorf("invalid period: %w", err)
+	}
+	return HPAScalingPolicy{Type: parts[0], Value: value, PeriodSeconds: period}, nil
+}
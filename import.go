@@ -0,0 +1,761 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file written by import ("" for stdout)
+var importOutput string
+
+// An object read from the imported manifests
+type importedObject struct {
+	file   string
+	object map[string]interface{}
+}
+
+func (o importedObject) kind() string {
+	kind, _ := o.object["kind"].(string)
+	return kind
+}
+
+func (o importedObject) name() string {
+	meta, _ := o.object["metadata"].(map[string]interface{})
+	name, _ := meta["name"].(string)
+	return name
+}
+
+// A field the API server fills in, dropped on import while it holds value
+// (any value if nil)
+type serverField struct {
+	kind  string // "" for every kind
+	path  []string
+	value interface{}
+}
+
+// Fields set by the cluster rather than by the manifest author, as found in
+// kubectl get -o yaml output
+var serverFields = []serverField{
+	{"", []string{"status"}, nil},
+	{"", []string{"metadata", "uid"}, nil},
+	{"", []string{"metadata", "resourceVersion"}, nil},
+	{"", []string{"metadata", "generation"}, nil},
+	{"", []string{"metadata", "creationTimestamp"}, nil},
+	{"", []string{"metadata", "managedFields"}, nil},
+	{"", []string{"metadata", "selfLink"}, nil},
+	{"", []string{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"}, nil},
+	{"Deployment", []string{"metadata", "annotations", "deployment.kubernetes.io/revision"}, nil},
+	{"Deployment", []string{"spec", "progressDeadlineSeconds"}, 600},
+	{"Deployment", []string{"spec", "revisionHistoryLimit"}, 10},
+	{"Deployment", []string{"spec", "template", "metadata", "creationTimestamp"}, nil},
+	{"Deployment", []string{"spec", "template", "spec", "dnsPolicy"}, "ClusterFirst"},
+	{"Deployment", []string{"spec", "template", "spec", "restartPolicy"}, "Always"},
+	{"Deployment", []string{"spec", "template", "spec", "schedulerName"}, "default-scheduler"},
+	{"Deployment", []string{"spec", "template", "spec", "terminationGracePeriodSeconds"}, 30},
+	{"Deployment", []string{"spec", "template", "spec", "serviceAccount"}, nil},
+	{"Deployment", []string{"spec", "template", "spec", "containers", "[]", "terminationMessagePath"}, "/dev/termination-log"},
+	{"Deployment", []string{"spec", "template", "spec", "containers", "[]", "terminationMessagePolicy"}, "File"},
+	{"Service", []string{"spec", "clusterIP"}, nil},
+	{"Service", []string{"spec", "clusterIPs"}, nil},
+	{"Service", []string{"spec", "ipFamilies"}, nil},
+	{"Service", []string{"spec", "ipFamilyPolicy"}, nil},
+	{"Service", []string{"spec", "internalTrafficPolicy"}, "Cluster"},
+	{"Service", []string{"spec", "sessionAffinity"}, "None"},
+}
+
+func newImportCommand() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import DIR",
+		Short: "Infer a kcg.yaml config from existing manifests",
+		Long: "Read the Deployment, Service, Ingress, ConfigMap, Secret, ResourceQuota, VPA, HPA and PodDisruptionBudget manifests in DIR, " +
+			"write the equivalent kcg.yaml config and report every field the config can't represent.",
+		Args: cobra.ExactArgs(1),
+		RunE: runImport,
+	}
+	importCmd.Flags().StringVarP(&importOutput, "output", "o", "", "Write the config to this file instead of stdout")
+	return importCmd
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	objects, err := readImportedObjects(dir)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := inferConfig(objects)
+	if err != nil {
+		return err
+	}
+
+	differences, added, err := importDifferences(cfg, objects)
+	if err != nil {
+		return err
+	}
+
+	data, err := marshalConfig(cfg)
+	if err != nil {
+		return err
+	}
+	data = append([]byte(fmt.Sprintf("# Imported from %s\n", dir)), data...)
+
+	if importOutput == "" {
+		fmt.Print(string(data))
+	} else {
+		if err := os.WriteFile(importOutput, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", importOutput, err)
+		}
+		pterm.Success.Printf("Imported %d object(s) from %s into %s\n", len(objects), dir, importOutput)
+	}
+
+	// Report on stderr so the config can be piped
+	if len(added) > 0 {
+		pterm.Info.WithWriter(os.Stderr).Printf("Generating from the config also creates: %s\n", strings.Join(added, ", "))
+	}
+	if len(differences) > 0 {
+		warnf("%d difference(s) can't be represented in the config and would change when regenerating:", len(differences))
+		for _, d := range differences {
+			fmt.Fprintf(os.Stderr, "  %s\n", d)
+		}
+	}
+	return nil
+}
+
+// Read every object in the manifest files under dir, expanding List objects
+func readImportedObjects(dir string) ([]importedObject, error) {
+	var objects []importedObject
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isManifestFile(p) {
+			return nil
+		}
+		docs, err := readYAMLDocuments(p)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", p, err)
+		}
+		for _, doc := range docs {
+			if doc["kind"] == "List" {
+				items, _ := doc["items"].([]interface{})
+				for _, item := range items {
+					if object, ok := item.(map[string]interface{}); ok {
+						objects = append(objects, importedObject{file: p, object: normalizeImported(object)})
+					}
+				}
+				continue
+			}
+			if doc["apiVersion"] == nil || doc["kind"] == nil {
+				continue
+			}
+			objects = append(objects, importedObject{file: p, object: normalizeImported(doc)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no Kubernetes objects found in %s", dir)
+	}
+	return objects, nil
+}
+
+// Drop the fields the cluster sets, so exported objects import like hand-written ones
+func normalizeImported(object map[string]interface{}) map[string]interface{} {
+	for _, field := range serverFields {
+		if field.kind == "" || field.kind == object["kind"] {
+			dropServerField(object, field.path, field.value)
+		}
+	}
+	return object
+}
+
+func dropServerField(value interface{}, path []string, want interface{}) {
+	if path[0] == "[]" {
+		list, _ := value.([]interface{})
+		for _, item := range list {
+			dropServerField(item, path[1:], want)
+		}
+		return
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if len(path) > 1 {
+		dropServerField(object[path[0]], path[1:], want)
+		return
+	}
+	if current, ok := object[path[0]]; ok && (want == nil || fmt.Sprint(current) == fmt.Sprint(want)) {
+		delete(object, path[0])
+	}
+}
+
+// Decode a generic object into one of the generator's types
+func decodeObject(object map[string]interface{}, out interface{}) error {
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+// Infer the config that generates the imported objects as closely as possible
+func inferConfig(objects []importedObject) (*AppConfig, error) {
+	byKind := map[string][]importedObject{}
+	for _, o := range objects {
+		byKind[o.kind()] = append(byKind[o.kind()], o)
+	}
+
+	deployments := byKind["Deployment"]
+	if len(deployments) == 0 {
+		return nil, fmt.Errorf("no Deployment found; the config is built around a single Deployment")
+	}
+	if len(deployments) > 1 {
+		var names []string
+		for _, d := range deployments {
+			names = append(names, d.name())
+		}
+		return nil, fmt.Errorf("found %d Deployments (%s); import reads a single Deployment, so import one at a time and add the other components to the config by hand", len(deployments), strings.Join(names, ", "))
+	}
+
+	var deployment Deployment
+	if err := decodeObject(deployments[0].object, &deployment); err != nil {
+		return nil, fmt.Errorf("failed to read Deployment %s: %w", deployments[0].name(), err)
+	}
+	podSpec := deployment.Spec.Template.Spec
+	if len(podSpec.Containers) == 0 {
+		return nil, fmt.Errorf("Deployment %s has no containers", deployment.Metadata.Name)
+	}
+
+	cfg := &AppConfig{APIVersion: configAPIVersion, Kind: configKind}
+	name := strings.TrimSuffix(deployment.Metadata.Name, "-node")
+	cfg.App.Name = name
+
+	// The generator names the container after the Deployment
+	container := podSpec.Containers[0]
+	for _, c := range podSpec.Containers {
+		if c.Name == deployment.Metadata.Name {
+			container = c
+		}
+	}
+
+	// <app>-staging and <app>-production are the environment's default namespaces
+	ns := deployment.Metadata.Namespace
+	for _, envName := range []string{"staging", "production"} {
+		if ns == fmt.Sprintf("%s-%s", name, envName) {
+			cfg.Environment = envName
+		}
+	}
+	if cfg.Environment == "" {
+		cfg.App.Namespace = ns
+	}
+
+	// Image
+	cfg.Image.Repository, cfg.Image.Tag = splitImage(container.Image)
+	for _, secret := range podSpec.ImagePullSecrets {
+		cfg.Image.PullSecrets = append(cfg.Image.PullSecrets, secret.Name)
+	}
+
+	if len(container.Ports) > 0 {
+		cfg.App.ContainerPort = intPtr(container.Ports[0].ContainerPort)
+	}
+	if deployment.Spec.Replicas != nil {
+		cfg.App.Replicas = intPtr(int(*deployment.Spec.Replicas))
+	}
+
+	// Service account; pods without one run as "default"
+	switch podSpec.ServiceAccountName {
+	case name:
+	case "":
+		cfg.ServiceAccount.Name = "default"
+	default:
+		cfg.ServiceAccount.Name = podSpec.ServiceAccountName
+	}
+	if len(byKind["ServiceAccount"]) == 0 {
+		create := false
+		cfg.ServiceAccount.Create = &create
+	}
+
+	cfg.Resources = resourcesSectionFrom(container.Resources)
+
+	// Other containers become sidecars; volumes are shared between all of them
+	for _, c := range podSpec.Containers {
+		if c.Name != container.Name {
+			cfg.Sidecars = append(cfg.Sidecars, containerConfigFrom(c))
+		}
+	}
+	for _, c := range podSpec.InitContainers {
+		cfg.InitContainers = append(cfg.InitContainers, containerConfigFrom(c))
+	}
+	cfg.Volumes = podSpec.Volumes
+	cfg.App.VolumeMounts = container.VolumeMounts
+
+	// Environment variables from the ConfigMap, Secret and container env
+	env, encoding, err := importEnvVars(container, byKind, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Env = env
+	cfg.EnvFiles.SecretEncoding = encoding
+
+	// Probes
+	cfg.Probes.Liveness = probeConfigFrom(container.LivenessProbe)
+	cfg.Probes.Readiness = probeConfigFrom(container.ReadinessProbe)
+	cfg.Probes.Startup = probeConfigFrom(container.StartupProbe)
+
+	// Scheduling
+	cfg.Scheduling = SchedulingConfig{
+		NodeSelector:              podSpec.NodeSelector,
+		Affinity:                  podSpec.Affinity,
+		Tolerations:               podSpec.Tolerations,
+		TopologySpreadConstraints: podSpec.TopologySpreadConstraints,
+	}
+
+	// Ingress
+	if ingresses := byKind["Ingress"]; len(ingresses) > 0 {
+		var ingress Ingress
+		if err := decodeObject(ingresses[0].object, &ingress); err != nil {
+			return nil, fmt.Errorf("failed to read Ingress %s: %w", ingresses[0].name(), err)
+		}
+		enabled := true
+		cfg.Ingress.Enabled = &enabled
+		cfg.Ingress.ClassName = ingress.Spec.IngressClassName
+		if len(ingress.Spec.Rules) > 0 {
+			cfg.Ingress.Host = ingress.Spec.Rules[0].Host
+		}
+		if len(ingress.Spec.TLS) > 0 {
+			cfg.Ingress.TLSSecret = ingress.Spec.TLS[0].SecretName
+		}
+	}
+
+	// VPA and ResourceQuota are on/off
+	if len(byKind["VerticalPodAutoscaler"]) > 0 {
+		enabled := true
+		cfg.VPA.Enabled = &enabled
+	}
+	if len(byKind["ResourceQuota"]) > 0 {
+		enabled := true
+		cfg.ResourceQuota.Enabled = &enabled
+	}
+
+	// HPA
+	if hpas := byKind["HorizontalPodAutoscaler"]; len(hpas) > 0 {
+		var hpa HPA
+		if err := decodeObject(hpas[0].object, &hpa); err != nil {
+			return nil, fmt.Errorf("failed to read HorizontalPodAutoscaler %s: %w", hpas[0].name(), err)
+		}
+		enabled := true
+		cfg.HPA.Enabled = &enabled
+		if hpa.Spec.MinReplicas != nil {
+			cfg.HPA.MinReplicas = intPtr(int(*hpa.Spec.MinReplicas))
+		}
+		cfg.HPA.MaxReplicas = intPtr(int(hpa.Spec.MaxReplicas))
+
+		// A missing metric is written as 0 to turn off the default target
+		cpuTarget, memoryTarget := 0, 0
+		for _, metric := range hpa.Spec.Metrics {
+			if metric.Type != "Resource" || metric.Resource.Target.Type != "Utilization" {
+				continue
+			}
+			switch metric.Resource.Name {
+			case "cpu":
+				cpuTarget = metric.Resource.Target.AverageUtilization
+			case "memory":
+				memoryTarget = metric.Resource.Target.AverageUtilization
+			}
+		}
+		cfg.HPA.CPUTarget = intPtr(cpuTarget)
+		cfg.HPA.MemoryTarget = intPtr(memoryTarget)
+		cfg.HPA.Behavior = hpa.Spec.Behavior
+	}
+
+	// PDB; production gets one by default, so its absence is made explicit
+	if pdbs := byKind["PodDisruptionBudget"]; len(pdbs) > 0 {
+		var pdb PodDisruptionBudget
+		if err := decodeObject(pdbs[0].object, &pdb); err != nil {
+			return nil, fmt.Errorf("failed to read PodDisruptionBudget %s: %w", pdbs[0].name(), err)
+		}
+		enabled := true
+		cfg.PDB.Enabled = &enabled
+		if pdb.Spec.MinAvailable != nil {
+			cfg.PDB.MinAvailable = fmt.Sprint(pdb.Spec.MinAvailable)
+		} else if pdb.Spec.MaxUnavailable != nil && fmt.Sprint(pdb.Spec.MaxUnavailable) != "1" {
+			cfg.PDB.MaxUnavailable = fmt.Sprint(pdb.Spec.MaxUnavailable)
+		}
+	} else if cfg.Environment == "production" {
+		enabled := false
+		cfg.PDB.Enabled = &enabled
+	}
+
+	return cfg, nil
+}
+
+// Split an image reference into repository and tag; the tag defaults to latest
+func splitImage(image string) (repo, tag string) {
+	if at := strings.Index(image, "@"); at >= 0 {
+		image = image[:at]
+	}
+	slash := strings.LastIndex(image, "/")
+	if colon := strings.LastIndex(image, ":"); colon > slash {
+		return image[:colon], image[colon+1:]
+	}
+	return image, "latest"
+}
+
+// Requests and limits of a container's resources
+func resourcesSectionFrom(resources map[string]interface{}) ResourcesSection {
+	requests, _ := resources["requests"].(map[string]interface{})
+	limits, _ := resources["limits"].(map[string]interface{})
+	return ResourcesSection{
+		Requests: ResourceValues{CPU: quantityString(requests["cpu"]), Memory: quantityString(requests["memory"])},
+		Limits:   ResourceValues{CPU: quantityString(limits["cpu"]), Memory: quantityString(limits["memory"])},
+	}
+}
+
+// Convert a sidecar or init container back into its config
+func containerConfigFrom(c Container) ContainerConfig {
+	return ContainerConfig{
+		Name:          c.Name,
+		Image:         c.Image,
+		Command:       c.Command,
+		Args:          c.Args,
+		Ports:         c.Ports,
+		Env:           c.Env,
+		UseAppEnv:     len(c.EnvFrom) > 0,
+		Resources:     resourcesSectionFrom(c.Resources),
+		VolumeMounts:  c.VolumeMounts,
+		RestartPolicy: c.RestartPolicy,
+	}
+}
+
+func quantityString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// Environment variables from the ConfigMap and Secret the container loads with
+// envFrom, plus its own env entries. Also returns the Secret encoding and
+// infers the environment from APP_ENV when the namespace did not.
+func importEnvVars(container Container, byKind map[string][]importedObject, cfg *AppConfig) ([]EnvVarConfig, string, error) {
+	var vars []EnvVarConfig
+	encoding := ""
+
+	for _, source := range container.EnvFrom {
+		if ref, ok := source["configMapRef"].(map[string]interface{}); ok {
+			object, found := findImported(byKind["ConfigMap"], ref["name"])
+			if !found {
+				continue
+			}
+			var configMap ConfigMap
+			if err := decodeObject(object.object, &configMap); err != nil {
+				return nil, "", fmt.Errorf("failed to read ConfigMap %s: %w", object.name(), err)
+			}
+
+			// The generator adds APP_ENV for every named environment; other
+			// names than staging and production need their default namespace
+			// or none at all, as rendered to stdout
+			defaultNamespace := fmt.Sprintf("%s-%s", cfg.App.Name, configMap.Data["APP_ENV"])
+			if appEnv, ok := configMap.Data["APP_ENV"]; ok && (appEnv == "staging" || appEnv == "production" ||
+				(dns1123LabelPattern.MatchString(appEnv) && (cfg.App.Namespace == "" || cfg.App.Namespace == defaultNamespace))) {
+				if cfg.Environment == "" {
+					cfg.Environment = appEnv
+					if cfg.App.Namespace == defaultNamespace {
+						cfg.App.Namespace = ""
+					}
+				}
+				if appEnv == cfg.Environment {
+					delete(configMap.Data, "APP_ENV")
+				}
+			}
+			for _, key := range sortedKeys(configMap.Data) {
+				vars = append(vars, EnvVarConfig{Name: key, Value: configMap.Data[key]})
+			}
+		}
+
+		if ref, ok := source["secretRef"].(map[string]interface{}); ok {
+			object, found := findImported(byKind["Secret"], ref["name"])
+			if !found {
+				continue
+			}
+			var secret Secret
+			if err := decodeObject(object.object, &secret); err != nil {
+				return nil, "", fmt.Errorf("failed to read Secret %s: %w", object.name(), err)
+			}
+
+			values := map[string]string{}
+			for key, value := range secret.Data {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return nil, "", fmt.Errorf("Secret %s: data.%s is not valid base64", secret.Metadata.Name, key)
+				}
+				values[key] = string(decoded)
+			}
+			if len(secret.Data) > 0 {
+				encoding = "base64"
+			}
+			for key, value := range secret.StringData {
+				values[key] = value
+			}
+
+			// Skip the placeholder the generator writes for an empty Secret
+			if placeholder, ok := values["APP_KEY"]; ok && placeholder == "" && len(values) == 1 {
+				continue
+			}
+			for _, key := range sortedKeys(values) {
+				vars = append(vars, EnvVarConfig{Name: key, Value: values[key], Secret: true})
+			}
+		}
+	}
+
+	// Literal values end up in the ConfigMap; references stay in the container
+	for _, v := range container.Env {
+		vars = append(vars, EnvVarConfig{Name: v.Name, Value: v.Value, ValueFrom: v.ValueFrom})
+	}
+	return vars, encoding, nil
+}
+
+func findImported(objects []importedObject, name interface{}) (importedObject, bool) {
+	for _, o := range objects {
+		if o.name() == name {
+			return o, true
+		}
+	}
+	return importedObject{}, false
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Convert a container probe back into a probe config
+func probeConfigFrom(probe map[string]interface{}) *ProbeConfig {
+	if len(probe) == 0 {
+		return nil
+	}
+
+	config := &ProbeConfig{}
+	if httpGet, ok := probe["httpGet"].(map[string]interface{}); ok {
+		config.Type = "http"
+		config.Path = "/"
+		if path, ok := httpGet["path"].(string); ok {
+			config.Path = path
+		}
+		config.Port = probeConfigPort(httpGet["port"])
+		config.Scheme, _ = httpGet["scheme"].(string)
+	} else if tcpSocket, ok := probe["tcpSocket"].(map[string]interface{}); ok {
+		config.Type = "tcp"
+		config.Port = probeConfigPort(tcpSocket["port"])
+	} else if exec, ok := probe["exec"].(map[string]interface{}); ok {
+		config.Type = "exec"
+		command, _ := exec["command"].([]interface{})
+		for _, arg := range command {
+			config.Command = append(config.Command, fmt.Sprint(arg))
+		}
+	} else if grpc, ok := probe["grpc"].(map[string]interface{}); ok {
+		config.Type = "grpc"
+		config.Port = probeConfigPort(grpc["port"])
+		config.Service, _ = grpc["service"].(string)
+	} else {
+		return nil
+	}
+
+	timing := func(key string) *int {
+		if v, ok := probe[key].(int); ok {
+			return intPtr(v)
+		}
+		return nil
+	}
+	config.InitialDelaySeconds = timing("initialDelaySeconds")
+	config.PeriodSeconds = timing("periodSeconds")
+	config.TimeoutSeconds = timing("timeoutSeconds")
+	config.SuccessThreshold = timing("successThreshold")
+	config.FailureThreshold = timing("failureThreshold")
+	return config
+}
+
+// The named container port is the probe default and is left out
+func probeConfigPort(port interface{}) string {
+	if port == nil || port == "http-port" {
+		return ""
+	}
+	return fmt.Sprint(port)
+}
+
+// Generate from the inferred config and describe every difference from the
+// imported objects. Also returns the objects that would be added.
+func importDifferences(cfg *AppConfig, objects []importedObject) (differences, added []string, err error) {
+	applyConfig(cfg, func(string) bool { return false })
+	if err := resolveEnvironments(func(string) bool { return false }); err != nil {
+		return nil, nil, err
+	}
+
+	// Imported values are reported as they are, not rejected
+	skipValidation = true
+
+	if err := resolveProbes(); err != nil {
+		return nil, nil, fmt.Errorf("inferred probes are not supported: %w", err)
+	}
+	if err := resolveEnvVars(); err != nil {
+		return nil, nil, fmt.Errorf("inferred environment variables are not supported: %w", err)
+	}
+	if err := resolveScheduling(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolveStatefulSet(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolveComponents(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolveJobs(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolvePersistentVolumeClaims(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolvePDB(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolveNetworkPolicy(); err != nil {
+		return nil, nil, err
+	}
+	if err := resolveLabels(); err != nil {
+		return nil, nil, err
+	}
+
+	target := singleEnvironmentTarget(env)
+	manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate manifests from the inferred config: %w", err)
+	}
+	var generated []map[string]interface{}
+	for _, manifest := range manifests {
+		object, err := toObject(manifest)
+		if err != nil {
+			return nil, nil, err
+		}
+		generated = append(generated, object)
+	}
+
+	// Pair objects by kind and name, falling back to the only object of a kind
+	used := make([]bool, len(generated))
+	match := func(o importedObject) int {
+		fallback := -1
+		for i, g := range generated {
+			if used[i] || g["kind"] != o.kind() {
+				continue
+			}
+			if parityKey(g) == parityKey(o.object) {
+				return i
+			}
+			if fallback < 0 {
+				fallback = i
+			}
+		}
+		return fallback
+	}
+
+	for _, o := range objects {
+		key := parityKey(o.object)
+		i := match(o)
+		if i < 0 {
+			differences = append(differences, fmt.Sprintf("%s (%s): not generated", key, o.file))
+			continue
+		}
+		used[i] = true
+		want := pruneEmpty(generated[i])
+		have := alignScalars(pruneEmpty(o.object), want)
+		differences = append(differences, diffPaths(key, have, want, "imported", "generated")...)
+	}
+	for i, g := range generated {
+		if !used[i] {
+			added = append(added, parityKey(g))
+		}
+	}
+	return differences, added, nil
+}
+
+// Take the generated value where a scalar only differs in type, so 1 and "1" match
+func alignScalars(imported, generated interface{}) interface{} {
+	switch v := imported.(type) {
+	case map[string]interface{}:
+		other, _ := generated.(map[string]interface{})
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = alignScalars(item, other[key])
+		}
+		return result
+	case []interface{}:
+		other, _ := generated.([]interface{})
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			if i < len(other) {
+				result[i] = alignScalars(item, other[i])
+			} else {
+				result[i] = item
+			}
+		}
+		return result
+	}
+	if imported != nil && generated != nil && fmt.Sprint(imported) == fmt.Sprint(generated) {
+		return generated
+	}
+	return imported
+}
+
+// Marshal the config without the unset fields
+func marshalConfig(cfg *AppConfig) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	pruneEmptyNodes(&node)
+	return yaml.Marshal(&node)
+}
+
+// Remove null, empty string and empty collection values from mappings.
+// Reports whether the node itself is empty.
+func pruneEmptyNodes(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			pruneEmptyNodes(child)
+		}
+		return false
+	case yaml.MappingNode:
+		var content []*yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if !pruneEmptyNodes(node.Content[i+1]) {
+				content = append(content, node.Content[i], node.Content[i+1])
+			}
+		}
+		node.Content = content
+		return len(content) == 0
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			pruneEmptyNodes(child)
+		}
+		return len(node.Content) == 0
+	case yaml.ScalarNode:
+		return node.Tag == "!!null" || (node.Tag == "!!str" && node.Value == "")
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Job struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   Metadata `yaml:"metadata"`
+	Spec       JobSpec  `yaml:"spec"`
+}
+
+type JobSpec struct {
+	BackoffLimit            *int        `yaml:"backoffLimit,omitempty"`
+	ActiveDeadlineSeconds   *int        `yaml:"activeDeadlineSeconds,omitempty"`
+	TTLSecondsAfterFinished *int        `yaml:"ttlSecondsAfterFinished,omitempty"`
+	Template                PodTemplate `yaml:"template"`
+}
+
+type CronJob struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   Metadata    `yaml:"metadata"`
+	Spec       CronJobSpec `yaml:"spec"`
+}
+
+type CronJobSpec struct {
+	Schedule                   string          `yaml:"schedule"`
+	TimeZone                   string          `yaml:"timeZone,omitempty"`
+	ConcurrencyPolicy          string          `yaml:"concurrencyPolicy,omitempty"`
+	StartingDeadlineSeconds    *int            `yaml:"startingDeadlineSeconds,omitempty"`
+	Suspend                    *bool           `yaml:"suspend,omitempty"`
+	SuccessfulJobsHistoryLimit *int            `yaml:"successfulJobsHistoryLimit,omitempty"`
+	FailedJobsHistoryLimit     *int            `yaml:"failedJobsHistoryLimit,omitempty"`
+	JobTemplate                JobTemplateSpec `yaml:"jobTemplate"`
+}
+
+type JobTemplateSpec struct {
+	Spec JobSpec `yaml:"spec"`
+}
+
+// JobConfig declares a batch workload running the app image: a CronJob when
+// it has a schedule, a one-off Job otherwise
+type JobConfig struct {
+	Name     string   `yaml:"name"`
+	Command  []string `yaml:"command"`
+	Args     []string `yaml:"args"`
+	Schedule string   `yaml:"schedule"`
+	// Per-environment schedules replacing schedule
+	Schedules                  map[string]string `yaml:"schedules"`
+	TimeZone                   string            `yaml:"timeZone"`
+	ConcurrencyPolicy          string            `yaml:"concurrencyPolicy"`
+	StartingDeadlineSeconds    *int              `yaml:"startingDeadlineSeconds"`
+	Suspend                    *bool             `yaml:"suspend"`
+	SuccessfulJobsHistoryLimit *int              `yaml:"successfulJobsHistoryLimit"`
+	FailedJobsHistoryLimit     *int              `yaml:"failedJobsHistoryLimit"`
+	BackoffLimit               *int              `yaml:"backoffLimit"`
+	ActiveDeadlineSeconds      *int              `yaml:"activeDeadlineSeconds"`
+	TTLSecondsAfterFinished    *int              `yaml:"ttlSecondsAfterFinished"`
+	RestartPolicy              string            `yaml:"restartPolicy"`
+	Resources                  ResourcesSection  `yaml:"resources"`
+}
+
+var jobConfigs []JobConfig
+
+// Five cron fields or one of the @ macros the CronJob controller accepts
+var (
+	cronFieldPattern = regexp.MustCompile(`^[0-9A-Za-z*?/,-]+$`)
+	cronMacros       = []string{"@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly"}
+)
+
+func (j JobConfig) isCron() bool {
+	return j.Schedule != "" || len(j.Schedules) > 0
+}
+
+// Schedule of a CronJob in an environment
+func (j JobConfig) scheduleFor(envName string) (string, error) {
+	if schedule, ok := j.Schedules[envName]; ok {
+		return schedule, nil
+	}
+	if j.Schedule == "" {
+		return "", fmt.Errorf("job %s has no schedule for %s (set schedule or schedules.%s)", j.Name, environmentLabel(envName), envName)
+	}
+	return j.Schedule, nil
+}
+
+func environmentLabel(envName string) string {
+	if envName == "" {
+		return "the default environment"
+	}
+	return "the " + envName + " environment"
+}
+
+// Check the jobs from the config file before generating anything
+func resolveJobs() error {
+	names := map[string]bool{}
+	for _, c := range resolvedComponents {
+		names[c.deploymentName()] = true
+	}
+
+	for _, j := range jobConfigs {
+		if j.Name == "" {
+			return fmt.Errorf("job without a name")
+		}
+		name := fmt.Sprintf("%s-%s", appName, j.Name)
+		if names[name] {
+			return fmt.Errorf("job %s: name %s is already used", j.Name, name)
+		}
+		names[name] = true
+
+		if j.RestartPolicy != "" && j.RestartPolicy != "Never" && j.RestartPolicy != "OnFailure" {
+			return fmt.Errorf("job %s: restartPolicy must be Never or OnFailure", j.Name)
+		}
+		for _, v := range []*int{j.BackoffLimit, j.ActiveDeadlineSeconds, j.TTLSecondsAfterFinished} {
+			if v != nil && *v < 0 {
+				return fmt.Errorf("job %s: backoffLimit, activeDeadlineSeconds and ttlSecondsAfterFinished must not be negative", j.Name)
+			}
+		}
+
+		if !j.isCron() {
+			if j.TimeZone != "" || j.ConcurrencyPolicy != "" || j.StartingDeadlineSeconds != nil || j.Suspend != nil ||
+				j.SuccessfulJobsHistoryLimit != nil || j.FailedJobsHistoryLimit != nil {
+				return fmt.Errorf("job %s: CronJob settings require a schedule", j.Name)
+			}
+			continue
+		}
+
+		// Jobs created by a CronJob append an 11-character suffix to its name
+		if len(name) > 52 {
+			return fmt.Errorf("job %s: CronJob name %s is longer than 52 characters", j.Name, name)
+		}
+		switch j.ConcurrencyPolicy {
+		case "", "Allow", "Forbid", "Replace":
+		default:
+			return fmt.Errorf("job %s: concurrencyPolicy must be Allow, Forbid or Replace", j.Name)
+		}
+		for _, v := range []*int{j.StartingDeadlineSeconds, j.SuccessfulJobsHistoryLimit, j.FailedJobsHistoryLimit} {
+			if v != nil && *v < 0 {
+				return fmt.Errorf("job %s: startingDeadlineSeconds and history limits must not be negative", j.Name)
+			}
+		}
+
+		if j.Schedule != "" {
+			if err := validateCronSchedule(j.Schedule); err != nil {
+				return fmt.Errorf("job %s: invalid schedule %q: %w", j.Name, j.Schedule, err)
+			}
+		}
+		for envName, schedule := range j.Schedules {
+			if !knownEnvironment(envName) {
+				return fmt.Errorf("job %s: unknown environment %q in schedules (defined: %s)", j.Name, envName, strings.Join(environmentNames, ", "))
+			}
+			if err := validateCronSchedule(schedule); err != nil {
+				return fmt.Errorf("job %s: invalid schedule %q for %s: %w", j.Name, schedule, environmentLabel(envName), err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateCronSchedule(schedule string) error {
+	if strings.HasPrefix(schedule, "@") {
+		if containsString(cronMacros, schedule) || strings.HasPrefix(schedule, "@every ") {
+			return nil
+		}
+		return fmt.Errorf("unknown macro (expected %s or @every DURATION)", strings.Join(cronMacros, ", "))
+	}
+	if strings.Contains(schedule, "TZ=") {
+		return fmt.Errorf("set the time zone with timeZone instead of in the schedule")
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	for _, field := range fields {
+		if !cronFieldPattern.MatchString(field) {
+			return fmt.Errorf("invalid field %q", field)
+		}
+	}
+	return nil
+}
+
+// The job as a component without a port, so it gets the app container, the
+// preset volumes, the app-wide resources and pod labels like a Deployment
+func (j JobConfig) component() ComponentConfig {
+	return withPresetContainers(ComponentConfig{
+		Name:      j.Name,
+		Tier:      "job",
+		Port:      intPtr(0),
+		Command:   j.Command,
+		Args:      j.Args,
+		Resources: mergeResources(j.Resources, appResources()),
+	})
+}
+
+// Job spec in an environment, whose resources replace the app-wide ones
+func (j JobConfig) jobSpec(envName, tag string, useConfigMap, useSecret bool, env []EnvVar) JobSpec {
+	c := j.component()
+	c.Resources = mergeResources(j.Resources, environmentResources(envName))
+	podSpec := createPodSpec(c, tag, useConfigMap, useSecret, env)
+	podSpec.RestartPolicy = j.RestartPolicy
+	if podSpec.RestartPolicy == "" {
+		podSpec.RestartPolicy = "Never"
+	}
+	return JobSpec{
+		BackoffLimit:            j.BackoffLimit,
+		ActiveDeadlineSeconds:   j.ActiveDeadlineSeconds,
+		TTLSecondsAfterFinished: j.TTLSecondsAfterFinished,
+		Template: PodTemplate{
+			Metadata: Metadata{
+				Labels: j.component().selectorLabels(),
+			},
+			Spec: podSpec,
+		},
+	}
+}
+
+// Create Job resource
+func createJob(j JobConfig, envName, tag, ns string, useConfigMap, useSecret bool, env []EnvVar) *Job {
+	return &Job{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata: Metadata{
+			Name:      fmt.Sprintf("%s-%s", appName, j.Name),
+			Namespace: ns,
+		},
+		Spec: j.jobSpec(envName, tag, useConfigMap, useSecret, env),
+	}
+}
+
+// Create CronJob resource; overlapping runs are skipped unless the config
+// sets another concurrencyPolicy
+func createCronJob(j JobConfig, envName, tag, ns string, useConfigMap, useSecret bool, env []EnvVar) (*CronJob, error) {
+	schedule, err := j.scheduleFor(envName)
+	if err != nil {
+		return nil, err
+	}
+	concurrencyPolicy := j.ConcurrencyPolicy
+	if concurrencyPolicy == "" {
+		concurrencyPolicy = "Forbid"
+	}
+
+	return &CronJob{
+		APIVersion: "batch/v1",
+		Kind:       "CronJob",
+		Metadata: Metadata{
+			Name:      fmt.Sprintf("%s-%s", appName, j.Name),
+			Namespace: ns,
+		},
+		Spec: CronJobSpec{
+			Schedule:                   schedule,
+			TimeZone:                   j.TimeZone,
+			ConcurrencyPolicy:          concurrencyPolicy,
+			StartingDeadlineSeconds:    j.StartingDeadlineSeconds,
+			Suspend:                    j.Suspend,
+			SuccessfulJobsHistoryLimit: j.SuccessfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     j.FailedJobsHistoryLimit,
+			JobTemplate: JobTemplateSpec{
+				Spec: j.jobSpec(envName, tag, useConfigMap, useSecret, env),
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"gopkg.in/yaml.v3"
+)
+
+type Kustomization struct {
+	APIVersion string               `yaml:"apiVersion"`
+	Kind       string               `yaml:"kind"`
+	Namespace  string               `yaml:"namespace,omitempty"`
+	Resources  []string             `yaml:"resources,omitempty"`
+	Labels     []KustomizationLabel `yaml:"labels,omitempty"`
+	Patches    []KustomizationPatch `yaml:"patches,omitempty"`
+	Images     []KustomizationImage `yaml:"images,omitempty"`
+}
+
+type KustomizationLabel struct {
+	Pairs            map[string]string `yaml:"pairs"`
+	IncludeTemplates bool              `yaml:"includeTemplates,omitempty"`
+}
+
+type KustomizationPatch struct {
+	Path string `yaml:"path"`
+}
+
+type KustomizationImage struct {
+	Name   string `yaml:"name"`
+	NewTag string `yaml:"newTag"`
+}
+
+// Kinds that always live in the overlays, as in src/templates/nodejs
+var overlayOnlyKinds = map[string]bool{
+	"Namespace":      true,
+	"ConfigMap":      true,
+	"Secret":         true,
+	"SealedSecret":   true,
+	"ExternalSecret": true,
+	"Ingress":        true,
+}
+
+// Kinds that stay in the base and get a strategic merge patch per overlay
+var patchableKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"CronJob":     true,
+	"Job":         true,
+}
+
+// List merge keys used by strategic merge patches for pod specs
+var patchMergeKeys = map[string]string{
+	"containers":       "name",
+	"initContainers":   "name",
+	"env":              "name",
+	"volumes":          "name",
+	"imagePullSecrets": "name",
+	"volumeMounts":     "mountPath",
+	"ports":            "containerPort",
+}
+
+// Environments rendered with --format kustomize or --format helm
+func layoutTargets() ([]environmentTarget, error) {
+	if multiEnvironment() {
+		return environmentTargets(), nil
+	}
+	if env == "" {
+		return nil, fmt.Errorf("--format %s needs --env, --environments or --all-environments", outputFormat)
+	}
+	return []environmentTarget{singleEnvironmentTarget(env)}, nil
+}
+
+// Write a kustomize base with the common resources and one overlay per environment
+func createKustomizeLayout(rootDir string) error {
+	targets, err := layoutTargets()
+	if err != nil {
+		return err
+	}
+
+	// Generate every environment, without namespaces (set by the overlay), image
+	// tags (set by images:) or version labels (set by labels:)
+	envManifests := make([][]interface{}, len(targets))
+	for i, target := range targets {
+		manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
+		if err != nil {
+			return fmt.Errorf("failed to generate manifests for %s: %w", target.name, err)
+		}
+		for _, manifest := range manifests {
+			if meta := objectMeta(manifest); meta != nil {
+				meta.Namespace = ""
+			} else if patched, ok := manifest.(*PatchedObject); ok {
+				deleteMappingValue(mappingValue(patched.node, "metadata"), "namespace")
+			}
+			stripImageTag(manifest)
+			stripVersionLabel(manifest)
+		}
+		envManifests[i] = manifests
+	}
+
+	var base []interface{}
+	overlayResources := make([][]interface{}, len(targets))
+	overlayPatches := make([][]map[string]interface{}, len(targets))
+
+	for _, manifest := range envManifests[0] {
+		kind, name := manifestKindName(manifest)
+
+		// Find the same resource in the other environments
+		inAll, identical := true, true
+		variants := make([]interface{}, len(targets))
+		objects := make([]map[string]interface{}, len(targets))
+		for i := range targets {
+			variants[i] = findManifest(envManifests[i], kind, name)
+			if variants[i] == nil {
+				inAll = false
+				continue
+			}
+			object, err := toObject(variants[i])
+			if err != nil {
+				return err
+			}
+			objects[i] = object
+			if !reflect.DeepEqual(objects[0], object) {
+				identical = false
+			}
+		}
+		fullKind := objects[0]["kind"].(string)
+
+		switch {
+		case overlayOnlyKinds[fullKind] || !inAll:
+			for i, variant := range variants {
+				if variant != nil {
+					overlayResources[i] = append(overlayResources[i], variant)
+				}
+			}
+		case identical:
+			base = append(base, manifest)
+		case patchableKinds[fullKind]:
+			base = append(base, manifest)
+			for i := 1; i < len(targets); i++ {
+				if !reflect.DeepEqual(objects[0], objects[i]) {
+					overlayPatches[i] = append(overlayPatches[i], strategicMergePatch(objects[0], objects[i]))
+				}
+			}
+		default:
+			for i, variant := range variants {
+				overlayResources[i] = append(overlayResources[i], variant)
+			}
+		}
+	}
+
+	// Resources that only exist in later environments
+	for i := 1; i < len(targets); i++ {
+		for _, manifest := range envManifests[i] {
+			kind, name := manifestKindName(manifest)
+			if findManifest(envManifests[0], kind, name) == nil {
+				overlayResources[i] = append(overlayResources[i], manifest)
+			}
+		}
+	}
+
+	// Base
+	baseDir := filepath.Join(rootDir, "base")
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return fmt.Errorf("failed to create base directory: %w", err)
+	}
+	baseKustomization := Kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+	}
+	for _, manifest := range base {
+		filename, err := writeManifestToFile(manifest, baseDir)
+		if err != nil {
+			return err
+		}
+		baseKustomization.Resources = append(baseKustomization.Resources, filename)
+	}
+	if err := writeKustomization(baseKustomization, baseDir); err != nil {
+		return err
+	}
+	pterm.Success.Printf("  ✓ base created in %s (%d resources)\n", baseDir, len(base))
+
+	// Overlays
+	for i, target := range targets {
+		overlayDir := filepath.Join(rootDir, target.name)
+		if err := os.MkdirAll(overlayDir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target.name, err)
+		}
+
+		kustomization := Kustomization{
+			APIVersion: "kustomize.config.k8s.io/v1beta1",
+			Kind:       "Kustomization",
+			Namespace:  target.namespace,
+			Resources:  []string{"../base"},
+			Images: []KustomizationImage{
+				{Name: imageRepo, NewTag: target.imageTag},
+			},
+		}
+		if recommendedLabels && checkFormat("label-value", target.imageTag) == "" {
+			kustomization.Labels = []KustomizationLabel{
+				{Pairs: map[string]string{versionLabel: target.imageTag}, IncludeTemplates: true},
+			}
+		}
+
+		for _, manifest := range overlayResources[i] {
+			filename, err := writeManifestToFile(manifest, overlayDir)
+			if err != nil {
+				return fmt.Errorf("failed to write manifest for %s: %w", target.name, err)
+			}
+			kustomization.Resources = append(kustomization.Resources, filename)
+		}
+
+		for _, patch := range overlayPatches[i] {
+			filename, err := writePatchFile(patch, overlayDir)
+			if err != nil {
+				return fmt.Errorf("failed to write patch for %s: %w", target.name, err)
+			}
+			kustomization.Patches = append(kustomization.Patches, KustomizationPatch{Path: filename})
+		}
+
+		if err := writeKustomization(kustomization, overlayDir); err != nil {
+			return err
+		}
+		pterm.Success.Printf("  ✓ %s overlay created in %s (%d resources, %d patches)\n", target.name, overlayDir, len(overlayResources[i]), len(overlayPatches[i]))
+	}
+
+	pterm.Print("\n")
+	pterm.Success.Printf("Kustomize layout generated successfully!\n")
+	pterm.Info.Printf("Build an environment with: kustomize build %s\n", filepath.Join(rootDir, targets[0].name))
+
+	return nil
+}
+
+// Drop the tag from app images; overlays set it with images: newTag
+func stripImageTag(manifest interface{}) {
+	var containers []Container
+	switch m := manifest.(type) {
+	case *Deployment:
+		containers = m.Spec.Template.Spec.Containers
+	case *StatefulSet:
+		containers = m.Spec.Template.Spec.Containers
+	case *Job:
+		containers = m.Spec.Template.Spec.Containers
+	case *CronJob:
+		containers = m.Spec.JobTemplate.Spec.Template.Spec.Containers
+	case *PatchedObject:
+		for _, container := range m.containers() {
+			if image := mappingValue(container, "image"); image != nil && strings.HasPrefix(image.Value, imageRepo+":") {
+				image.Value = imageRepo
+			}
+		}
+	}
+	for i := range containers {
+		if strings.HasPrefix(containers[i].Image, imageRepo+":") {
+			containers[i].Image = imageRepo
+		}
+	}
+}
+
+// Drop the version label, which differs between environments; overlays set
+// it with labels:
+func stripVersionLabel(manifest interface{}) {
+	if patched, ok := manifest.(*PatchedObject); ok {
+		deleteMappingValue(mappingValue(mappingValue(patched.node, "metadata"), "labels"), versionLabel)
+		deleteMappingValue(mappingValue(mappingValue(patched.podTemplate(), "metadata"), "labels"), versionLabel)
+		return
+	}
+	if meta := objectMeta(manifest); meta != nil {
+		delete(meta.Labels, versionLabel)
+	}
+	if template := podTemplateMeta(manifest); template != nil {
+		delete(template.Labels, versionLabel)
+	}
+}
+
+func findManifest(manifests []interface{}, kind, name string) interface{} {
+	for _, manifest := range manifests {
+		k, n := manifestKindName(manifest)
+		if k == kind && n == name {
+			return manifest
+		}
+	}
+	return nil
+}
+
+// Convert a typed manifest to a generic object
+func toObject(manifest interface{}) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+	}
+	return object, nil
+}
+
+// Build a strategic merge patch that turns base into target
+func strategicMergePatch(base, target map[string]interface{}) map[string]interface{} {
+	patch := diffMaps(base, target)
+	if patch == nil {
+		patch = map[string]interface{}{}
+	}
+	patch["apiVersion"] = target["apiVersion"]
+	patch["kind"] = target["kind"]
+	patch["metadata"] = map[string]interface{}{
+		"name": target["metadata"].(map[string]interface{})["name"],
+	}
+	return patch
+}
+
+func diffMaps(base, target map[string]interface{}) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for key, targetValue := range target {
+		baseValue, ok := base[key]
+		if !ok {
+			patch[key] = targetValue
+			continue
+		}
+		if d, changed := diffValues(key, baseValue, targetValue); changed {
+			patch[key] = d
+		}
+	}
+	// Keys missing from the target are deleted with null
+	for key := range base {
+		if _, ok := target[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	return patch
+}
+
+func diffValues(key string, base, target interface{}) (interface{}, bool) {
+	if reflect.DeepEqual(base, target) {
+		return nil, false
+	}
+
+	baseMap, baseIsMap := base.(map[string]interface{})
+	targetMap, targetIsMap := target.(map[string]interface{})
+	if baseIsMap && targetIsMap {
+		return diffMaps(baseMap, targetMap), true
+	}
+
+	baseList, baseIsList := base.([]interface{})
+	targetList, targetIsList := target.([]interface{})
+	if mergeKey, ok := patchMergeKeys[key]; ok && baseIsList && targetIsList {
+		if d, ok := diffKeyedLists(mergeKey, baseList, targetList); ok {
+			return d, true
+		}
+	}
+
+	// Scalars and lists without a merge key are replaced
+	return target, true
+}
+
+// Diff two lists merged by key; returns false if an item lacks the key
+func diffKeyedLists(mergeKey string, base, target []interface{}) ([]interface{}, bool) {
+	baseItems := map[interface{}]map[string]interface{}{}
+	for _, item := range base {
+		m, ok := item.(map[string]interface{})
+		if !ok || m[mergeKey] == nil {
+			return nil, false
+		}
+		baseItems[m[mergeKey]] = m
+	}
+
+	var patch []interface{}
+	seen := map[interface{}]bool{}
+	for _, item := range target {
+		m, ok := item.(map[string]interface{})
+		if !ok || m[mergeKey] == nil {
+			return nil, false
+		}
+		key := m[mergeKey]
+		seen[key] = true
+
+		baseItem, ok := baseItems[key]
+		if !ok {
+			patch = append(patch, m)
+			continue
+		}
+		if d := diffMaps(baseItem, m); d != nil {
+			d[mergeKey] = key
+			patch = append(patch, d)
+		}
+	}
+
+	// Items missing from the target are deleted
+	var removed []string
+	for key := range baseItems {
+		if !seen[key] {
+			removed = append(removed, fmt.Sprint(key))
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		for _, item := range base {
+			m := item.(map[string]interface{})
+			if fmt.Sprint(m[mergeKey]) == key {
+				patch = append(patch, map[string]interface{}{mergeKey: m[mergeKey], "$patch": "delete"})
+			}
+		}
+	}
+
+	return patch, true
+}
+
+func writePatchFile(patch map[string]interface{}, outputDir string) (string, error) {
+	kind := fmt.Sprint(patch["kind"])
+	name := fmt.Sprint(patch["metadata"].(map[string]interface{})["name"])
+	filename := fmt.Sprintf("%s-%s-patch.yaml", fileKind(kind), name)
+
+	data, err := yaml.Marshal(patch)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	filePath := filepath.Join(outputDir, filename)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	return filename, nil
+}
+
+func writeKustomization(kustomization Kustomization, outputDir string) error {
+	data, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	filePath := filepath.Join(outputDir, "kustomization.yaml")
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	return nil
+}
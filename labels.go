@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelsSection adds labels to the generated objects. Selector labels are
+// never changed, as selectors are immutable once applied.
+type LabelsSection struct {
+	// app.kubernetes.io/version, part-of, component and managed-by
+	Recommended *bool  `yaml:"recommended"`
+	PartOf      string `yaml:"partOf"`
+	// Labels on every object and pod template
+	Common map[string]string `yaml:"common"`
+	// Labels on the objects of a kind; Pod is the pod templates of workloads
+	Kinds map[string]map[string]string `yaml:"kinds"`
+}
+
+// AnnotationsSection adds annotations to the generated objects
+type AnnotationsSection struct {
+	// Annotations on every object, but not on pod templates
+	Common map[string]string            `yaml:"common"`
+	Kinds  map[string]map[string]string `yaml:"kinds"`
+}
+
+var (
+	labelFlags        []string
+	annotationFlags   []string
+	recommendedLabels bool
+	partOf            string
+	labelConfig       LabelsSection
+	annotationConfig  AnnotationsSection
+)
+
+// Labels and annotations by kind, with "" for every object
+var (
+	resolvedLabels      map[string]map[string]string
+	resolvedAnnotations map[string]map[string]string
+)
+
+const (
+	versionLabel   = "app.kubernetes.io/version"
+	managedByValue = "k8s-config-generator"
+)
+
+// Kinds labels and annotations can be set on; Pod stands for the pod
+// templates of Deployments, StatefulSets, Jobs and CronJobs
+var metadataKinds = []string{
+	"Namespace", "ServiceAccount", "ConfigMap", "Secret", "SealedSecret", "ExternalSecret",
+	"PersistentVolumeClaim", "Deployment", "StatefulSet", "Service", "Ingress", "Job", "CronJob",
+	"NetworkPolicy", "ResourceQuota", "VerticalPodAutoscaler", "HorizontalPodAutoscaler",
+	"PodDisruptionBudget", "Pod",
+}
+
+// Merge the --label and --annotation flags on top of the config file and
+// check them. Runs after the components are resolved, as their selector
+// labels can't be set.
+func resolveLabels() error {
+	var err error
+	resolvedLabels, err = mergeMetadata("label", labelConfig.Common, labelConfig.Kinds, labelFlags)
+	if err != nil {
+		return err
+	}
+	resolvedAnnotations, err = mergeMetadata("annotation", annotationConfig.Common, annotationConfig.Kinds, annotationFlags)
+	if err != nil {
+		return err
+	}
+	if partOf != "" {
+		if reason := checkFormat("label-value", partOf); reason != "" {
+			return fmt.Errorf("invalid --part-of: %s", reason)
+		}
+	}
+	return nil
+}
+
+// Labels or annotations by kind from the config file, with the flags given
+// as [KIND:]KEY=VALUE replacing the same keys
+func mergeMetadata(what string, common map[string]string, kinds map[string]map[string]string, flags []string) (map[string]map[string]string, error) {
+	merged := map[string]map[string]string{}
+	add := func(kind, key, value string) error {
+		if kind != "" && !containsString(metadataKinds, kind) {
+			return fmt.Errorf("unknown kind %q (expected %s)", kind, strings.Join(metadataKinds, ", "))
+		}
+		if reason := checkFormat("qualified-name", key); reason != "" {
+			return fmt.Errorf("%s", reason)
+		}
+		if what == "label" {
+			if _, ok := primaryComponent().selectorLabels()[key]; ok {
+				return fmt.Errorf("%s is a selector label and can't be changed", key)
+			}
+			if reason := checkFormat("label-value", value); reason != "" {
+				return fmt.Errorf("%s", reason)
+			}
+		}
+		if merged[kind] == nil {
+			merged[kind] = map[string]string{}
+		}
+		merged[kind][key] = value
+		return nil
+	}
+
+	for _, key := range sortedKeys(common) {
+		if err := add("", key, common[key]); err != nil {
+			return nil, fmt.Errorf("%ss.common: %w", what, err)
+		}
+	}
+	var kindNames []string
+	for kind := range kinds {
+		kindNames = append(kindNames, kind)
+	}
+	sort.Strings(kindNames)
+	for _, kind := range kindNames {
+		for _, key := range sortedKeys(kinds[kind]) {
+			if err := add(kind, key, kinds[kind][key]); err != nil {
+				return nil, fmt.Errorf("%ss.kinds.%s: %w", what, kind, err)
+			}
+		}
+	}
+
+	for _, spec := range flags {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --%s %q: expected [KIND:]KEY=VALUE", what, spec)
+		}
+		kind, key := "", kv[0]
+		if i := strings.Index(key, ":"); i >= 0 {
+			kind, key = key[:i], key[i+1:]
+		}
+		if err := add(kind, key, kv[1]); err != nil {
+			return nil, fmt.Errorf("invalid --%s %q: %w", what, spec, err)
+		}
+	}
+	return merged, nil
+}
+
+// Value of app.kubernetes.io/part-of
+func partOfValue() string {
+	if partOf != "" {
+		return partOf
+	}
+	return appName
+}
+
+// Add the recommended, common and per-kind labels and annotations to the
+// generated objects and their pod templates. Selectors keep their own maps,
+// so nothing added here can change which pods they select.
+func applyLabels(manifests []interface{}, envName, tag string) error {
+	version := ""
+	if recommendedLabels {
+		if reason := checkFormat("label-value", tag); reason == "" {
+			version = tag
+		} else {
+			warnf("not adding %s to %s: image tag %s", versionLabel, environmentLabel(envName), reason)
+		}
+	}
+
+	for _, manifest := range manifests {
+		meta := objectMeta(manifest)
+		if meta == nil {
+			continue
+		}
+		kind, _, err := manifestIdentity(manifest)
+		if err != nil {
+			return err
+		}
+
+		recommended := recommendedLabelsFor(manifest, version)
+		meta.Labels = mergeLabels(meta.Labels, recommended, resolvedLabels[""], resolvedLabels[kind])
+		meta.Annotations = mergeLabels(meta.Annotations, resolvedAnnotations[""], resolvedAnnotations[kind])
+		if template := podTemplateMeta(manifest); template != nil {
+			template.Labels = mergeLabels(template.Labels, recommended, resolvedLabels[""], resolvedLabels["Pod"])
+			template.Annotations = mergeLabels(template.Annotations, resolvedAnnotations["Pod"])
+		}
+	}
+	return nil
+}
+
+// Recommended labels of an object; only objects that belong to a component
+// get app.kubernetes.io/component
+func recommendedLabelsFor(manifest interface{}, version string) map[string]string {
+	if !recommendedLabels {
+		return nil
+	}
+	labels := map[string]string{
+		"app.kubernetes.io/part-of":    partOfValue(),
+		"app.kubernetes.io/managed-by": managedByValue,
+	}
+	if version != "" {
+		labels[versionLabel] = version
+	}
+	if component := manifestComponent(manifest); component != "" {
+		labels["app.kubernetes.io/component"] = component
+	}
+	return labels
+}
+
+// Component an object belongs to, taken from the layer label it selects by
+func manifestComponent(manifest interface{}) string {
+	switch m := manifest.(type) {
+	case *Deployment:
+		return m.Spec.Selector.MatchLabels["layer"]
+	case *StatefulSet:
+		return m.Spec.Selector.MatchLabels["layer"]
+	case *Service:
+		return m.Spec.Selector["layer"]
+	case *Job:
+		return m.Spec.Template.Metadata.Labels["layer"]
+	case *CronJob:
+		return m.Spec.JobTemplate.Spec.Template.Metadata.Labels["layer"]
+	case *PodDisruptionBudget:
+		return m.Spec.Selector.MatchLabels["layer"]
+	case *HPA, *VPA:
+		return primaryComponent().Name
+	}
+	return ""
+}
+
+// Metadata of a workload's pod template
+func podTemplateMeta(manifest interface{}) *Metadata {
+	switch m := manifest.(type) {
+	case *Deployment:
+		return &m.Spec.Template.Metadata
+	case *StatefulSet:
+		return &m.Spec.Template.Metadata
+	case *Job:
+		return &m.Spec.Template.Metadata
+	case *CronJob:
+		return &m.Spec.JobTemplate.Spec.Template.Metadata
+	}
+	return nil
+}
+
+// A new map with the entries of each map in turn, later ones winning; the
+// existing map is returned as is when there is nothing to add
+func mergeLabels(existing map[string]string, additions ...map[string]string) map[string]string {
+	count := 0
+	for _, m := range additions {
+		count += len(m)
+	}
+	if count == 0 {
+		return existing
+	}
+	merged := make(map[string]string, len(existing)+count)
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for _, m := range additions {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,7 +11,6 @@ import (
 
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 // Kubernetes resource structs
@@ -101,7 +101,7 @@ type Selector struct {
 }
 
 type PodTemplate struct {
-	Metadata Metadata    `yaml:"metadata"`
+	Metadata Metadata    `yaml:"metadata,omitempty"`
 	Spec     PodSpec     `yaml:"spec"`
 }
 
@@ -109,10 +109,14 @@ type PodSpec struct {
 	ServiceAccountName string                 `yaml:"serviceAccountName,omitempty"`
 	ImagePullSecrets   []ImagePullSecretRef   `yaml:"imagePullSecrets,omitempty"`
 	SecurityContext    map[string]interface{} `yaml:"securityContext,omitempty"`
+	RestartPolicy      string                 `yaml:"restartPolicy,omitempty"`
+	InitContainers     []Container            `yaml:"initContainers,omitempty"`
 	Containers         []Container            `yaml:"containers"`
 	NodeSelector       map[string]string      `yaml:"nodeSelector,omitempty"`
 	Affinity           map[string]interface{} `yaml:"affinity,omitempty"`
 	Tolerations        []map[string]interface{} `yaml:"tolerations,omitempty"`
+	TopologySpreadConstraints []map[string]interface{} `yaml:"topologySpreadConstraints,omitempty"`
+	Volumes                   []Volume                 `yaml:"volumes,omitempty"`
 }
 
 type ImagePullSecretRef struct {
@@ -123,25 +127,31 @@ type Container struct {
 	Name            string                 `yaml:"name"`
 	Image           string                 `yaml:"image"`
 	ImagePullPolicy string                 `yaml:"imagePullPolicy,omitempty"`
+	Command         []string               `yaml:"command,omitempty"`
+	Args            []string               `yaml:"args,omitempty"`
 	Ports           []ContainerPort        `yaml:"ports,omitempty"`
-	Env             []map[string]string    `yaml:"env,omitempty"`
+	Env             []EnvVar               `yaml:"env,omitempty"`
 	EnvFrom         []map[string]interface{} `yaml:"envFrom,omitempty"`
 	Resources       map[string]interface{} `yaml:"resources,omitempty"`
 	SecurityContext map[string]interface{} `yaml:"securityContext,omitempty"`
 	LivenessProbe   map[string]interface{} `yaml:"livenessProbe,omitempty"`
 	ReadinessProbe  map[string]interface{} `yaml:"readinessProbe,omitempty"`
+	StartupProbe    map[string]interface{} `yaml:"startupProbe,omitempty"`
+	VolumeMounts    []VolumeMount          `yaml:"volumeMounts,omitempty"`
+	RestartPolicy   string                 `yaml:"restartPolicy,omitempty"`
 }
 
 type ContainerPort struct {
-	Name          string `yaml:"name"`
+	Name          string `yaml:"name,omitempty"`
 	ContainerPort int    `yaml:"containerPort"`
 	Protocol      string `yaml:"protocol,omitempty"`
 }
 
 type ServiceSpec struct {
-	Type     string            `yaml:"type,omitempty"`
-	Ports    []ServicePort     `yaml:"ports"`
-	Selector map[string]string `yaml:"selector"`
+	Type      string            `yaml:"type,omitempty"`
+	ClusterIP string            `yaml:"clusterIP,omitempty"`
+	Ports     []ServicePort     `yaml:"ports,omitempty"`
+	Selector  map[string]string `yaml:"selector"`
 }
 
 type ServicePort struct {
@@ -235,9 +245,64 @@ var (
 	resourceLimitsMemory string
 	render               bool
 	outputDir            string
+	configFile           string
+	outputFormat         string
+	helmValuesOnly       bool
+	hpaEnabled           bool
+	hpaMinReplicas       int
+	hpaMaxReplicas       int
+	hpaCPUTarget         int
+	hpaMemoryTarget      int
+	hpaScaleUpStabilization   int
+	hpaScaleDownStabilization int
+	hpaScaleUpSelectPolicy    string
+	hpaScaleDownSelectPolicy  string
+	hpaScaleUpPolicies        []string
+	hpaScaleDownPolicies      []string
+	hpaBehaviorConfig         HPABehavior
+	probePreset               string
+	livenessProbeSpec         string
+	readinessProbeSpec        string
+	startupProbeSpec          string
+	livenessProbeConfig       *ProbeConfig
+	readinessProbeConfig      *ProbeConfig
+	startupProbeConfig        *ProbeConfig
+	envVarFlags               []string
+	envVarStageFlags          []string
+	envVarProdFlags           []string
+	secretEnvVarFlags         []string
+	secretEnvVarStageFlags    []string
+	secretEnvVarProdFlags     []string
+	envVarFromFlags           []string
+	envVarConfigs             = map[string][]EnvVarConfig{}
+	configEnvFiles            []string
+	configEnvFilesStage       []string
+	configEnvFilesProd        []string
+	secretEnvFiles            []string
+	secretEnvFilesStage       []string
+	secretEnvFilesProd        []string
+	envFilesConfig            EnvFilesSection
+	secretDataEncoding        string
+	nodeSelectorFlags         []string
+	tolerationFlags           []string
+	schedulingPresets         []string
+	schedulingConfig          SchedulingConfig
+	sidecarConfigs            []ContainerConfig
+	initContainerConfigs      []ContainerConfig
+	volumeConfigs             []Volume
+	appVolumeMounts           []VolumeMount
+	componentConfigs          []ComponentConfig
 )
 
 func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// The root command; registering the flags resets them to their defaults
+func newRootCommand() *cobra.Command {
 	var rootCmd = &cobra.Command{
 		Use:   "k8s-config-generator",
 		Short: "Generate Kubernetes manifests",
@@ -245,6 +310,9 @@ func main() {
 		RunE:  run,
 	}
 
+	// Config file (flags override values from the file)
+	rootCmd.Flags().StringVar(&configFile, "config", "", "Path to a kcg.yaml config file")
+
 	// Required flags (optional - will prompt if not provided)
 	rootCmd.Flags().StringVar(&appName, "app-name", "", "Application name")
 	rootCmd.Flags().StringVar(&imageRepo, "image-repo", "", "Docker image repository")
@@ -253,15 +321,27 @@ func main() {
 	// Optional flags
 	rootCmd.Flags().StringVar(&namespace, "namespace", "", "Kubernetes namespace")
 	rootCmd.Flags().IntVar(&containerPort, "container-port", 3000, "Container port")
-	rootCmd.Flags().StringVar(&env, "env", "", "Environment (staging|production)")
-	rootCmd.Flags().BoolVar(&allEnvironments, "all-environments", false, "Generate manifests for both staging and production")
-	rootCmd.Flags().StringVar(&imageTagStage, "image-tag-stage", "", "Docker image tag for staging (used with --all-environments)")
-	rootCmd.Flags().StringVar(&imageTagProd, "image-tag-prod", "", "Docker image tag for production (used with --all-environments)")
+	rootCmd.Flags().StringVar(&presetName, "preset", "", "Runtime preset with default port, probes, resources, env vars and security context (see kcg presets)")
+	rootCmd.Flags().StringVar(&presetDir, "preset-dir", "", "Directory with user presets as NAME.yaml (default: $XDG_CONFIG_HOME/kcg/presets)")
+	rootCmd.Flags().StringVar(&env, "env", "", "Environment to generate, e.g. staging or production")
+	rootCmd.Flags().BoolVar(&allEnvironments, "all-environments", false, "Generate manifests for every defined environment (staging and production when none are defined)")
+	rootCmd.Flags().StringSliceVar(&selectedEnvironments, "environments", []string{}, "Generate manifests for these defined environments, e.g. dev,qa (narrows --all-environments)")
+	rootCmd.Flags().StringArrayVar(&environmentFlags, "environment-config", []string{}, "Define or override an environment as NAME[,imageTag=TAG][,namespace=NS][,ingressHost=HOST][,ingressTLSSecret=SECRET][,replicas=N][,resources.requests.cpu=CPU...][,pdb.enabled=BOOL...] (can be repeated)")
+	rootCmd.Flags().StringVar(&imageTagStage, "image-tag-stage", "", "Docker image tag for staging")
+	rootCmd.Flags().StringVar(&imageTagProd, "image-tag-prod", "", "Docker image tag for production")
 	rootCmd.Flags().StringVar(&ingressHostStage, "ingress-host-stage", "", "Ingress host for staging")
 	rootCmd.Flags().StringVar(&ingressHostProd, "ingress-host-prod", "", "Ingress host for production")
 	rootCmd.Flags().StringVar(&ingressTLSSecretStage, "ingress-tls-secret-stage", "", "Ingress TLS secret for staging")
 	rootCmd.Flags().StringVar(&ingressTLSSecretProd, "ingress-tls-secret-prod", "", "Ingress TLS secret for production")
+	for flag, key := range map[string]string{
+		"image-tag-stage": "staging,imageTag", "image-tag-prod": "production,imageTag",
+		"ingress-host-stage": "staging,ingressHost", "ingress-host-prod": "production,ingressHost",
+		"ingress-tls-secret-stage": "staging,ingressTLSSecret", "ingress-tls-secret-prod": "production,ingressTLSSecret",
+	} {
+		rootCmd.Flags().MarkDeprecated(flag, fmt.Sprintf("use --environment-config %s=VALUE", key))
+	}
 	rootCmd.Flags().IntVar(&replicas, "replicas", 1, "Number of replicas")
+	rootCmd.Flags().StringVar(&workload, "workload", "deployment", "Workload kind (deployment|statefulset)")
 	rootCmd.Flags().BoolVar(&ingressEnabled, "ingress-enabled", false, "Enable ingress")
 	rootCmd.Flags().StringVar(&ingressHost, "ingress-host", "", "Ingress host")
 	rootCmd.Flags().StringVar(&ingressClass, "ingress-class", "nginx", "Ingress class name")
@@ -276,14 +356,100 @@ func main() {
 	rootCmd.Flags().StringVar(&resourceLimitsCPU, "resources-limits-cpu", "", "Resource limits CPU")
 	rootCmd.Flags().StringVar(&resourceLimitsMemory, "resources-limits-memory", "", "Resource limits memory")
 
+	// Environment variable flags
+	rootCmd.Flags().StringArrayVar(&envVarFlags, "env-var", []string{}, "Environment variable [ENV:]KEY=VALUE stored in the ConfigMap; ENV: limits it to one environment (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&envVarStageFlags, "env-var-stage", []string{}, "Staging-only environment variable KEY=VALUE, same as --env-var staging:KEY=VALUE (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&envVarProdFlags, "env-var-prod", []string{}, "Production-only environment variable KEY=VALUE, same as --env-var production:KEY=VALUE (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&secretEnvVarFlags, "secret-env-var", []string{}, "Sensitive environment variable [ENV:]KEY=VALUE stored in the Secret; ENV: limits it to one environment (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&secretEnvVarStageFlags, "secret-env-var-stage", []string{}, "Staging-only sensitive environment variable KEY=VALUE (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&secretEnvVarProdFlags, "secret-env-var-prod", []string{}, "Production-only sensitive environment variable KEY=VALUE (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&envVarFromFlags, "env-var-from", []string{}, "Environment variable from a reference, KEY=fieldRef:PATH, KEY=resourceFieldRef:RESOURCE, KEY=secretKeyRef:NAME/KEY or KEY=configMapKeyRef:NAME/KEY (can be repeated)")
+
+	// Env file flags
+	rootCmd.Flags().StringArrayVar(&configEnvFiles, "config-from-env-file", []string{}, "Load ConfigMap data from a .env file, as [ENV:]PATH for a single environment (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&configEnvFilesStage, "config-from-env-file-stage", []string{}, "Load staging ConfigMap data from a .env file (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&configEnvFilesProd, "config-from-env-file-prod", []string{}, "Load production ConfigMap data from a .env file (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&secretEnvFiles, "secret-from-env-file", []string{}, "Load Secret data from a .env file, as [ENV:]PATH for a single environment (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&secretEnvFilesStage, "secret-from-env-file-stage", []string{}, "Load staging Secret data from a .env file (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&secretEnvFilesProd, "secret-from-env-file-prod", []string{}, "Load production Secret data from a .env file (can be repeated)")
+	rootCmd.Flags().StringVar(&secretDataEncoding, "secret-data-encoding", "stringData", "How Secret values are written (stringData|base64)")
+
+	// Secret format flags
+	rootCmd.Flags().StringVar(&secretFormat, "secret-format", "plain", "How the Secret is written (plain|sops|sealed-secrets|external-secrets)")
+	rootCmd.Flags().StringArrayVar(&sopsAgeRecipients, "sops-age-recipient", []string{}, "age public key the sops-encrypted Secret is encrypted for (can be repeated)")
+	rootCmd.Flags().StringVar(&sealedSecretsCert, "sealed-secrets-cert", "", "Certificate of the sealed-secrets controller, as fetched with kubeseal --fetch-cert")
+	rootCmd.Flags().StringVar(&sealedSecretsScope, "sealed-secrets-scope", "strict", "SealedSecret scope (strict|namespace-wide|cluster-wide)")
+	rootCmd.Flags().StringVar(&externalSecretsStore, "external-secrets-store", "", "Name of the SecretStore the ExternalSecret reads from")
+	rootCmd.Flags().StringVar(&externalSecretsStoreKind, "external-secrets-store-kind", "SecretStore", "Kind of the secret store (SecretStore|ClusterSecretStore)")
+	rootCmd.Flags().StringVar(&externalSecretsRemoteKey, "external-secrets-remote-key", "", "Key of the secret in the store; {env} is replaced by the environment (default: <app-name>/<env>)")
+	rootCmd.Flags().StringVar(&externalSecretsRefreshInterval, "external-secrets-refresh-interval", "1h", "How often the ExternalSecret is synced from the store")
+
+	// Scheduling flags
+	rootCmd.Flags().StringArrayVar(&nodeSelectorFlags, "node-selector", []string{}, "Node selector KEY=VALUE (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&tolerationFlags, "toleration", []string{}, "Toleration KEY[=VALUE][:EFFECT] (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&schedulingPresets, "scheduling-preset", []string{}, "Scheduling preset: "+schedulingPresetList()+" (can be repeated)")
+
+	// Health check flags
+	rootCmd.Flags().StringVar(&probePreset, "probe-preset", "", "Probe preset (node: /healthz liveness/startup and /ready readiness)")
+	rootCmd.Flags().StringVar(&livenessProbeSpec, "liveness-probe", "", "Liveness probe as TYPE[:TARGET][,key=value...], e.g. http:/healthz,initialDelaySeconds=10")
+	rootCmd.Flags().StringVar(&readinessProbeSpec, "readiness-probe", "", "Readiness probe as TYPE[:TARGET][,key=value...], e.g. http:/ready")
+	rootCmd.Flags().StringVar(&startupProbeSpec, "startup-probe", "", "Startup probe as TYPE[:TARGET][,key=value...], e.g. http:/healthz,failureThreshold=30")
+
+	// StatefulSet flags
+	rootCmd.Flags().StringVar(&podManagementPolicy, "pod-management-policy", "OrderedReady", "StatefulSet pod management policy (OrderedReady|Parallel)")
+	rootCmd.Flags().StringVar(&statefulSetUpdateStrategy, "statefulset-update-strategy", "RollingUpdate", "StatefulSet update strategy (RollingUpdate|OnDelete)")
+	rootCmd.Flags().IntVar(&statefulSetPartition, "statefulset-partition", 0, "Only update StatefulSet pods with an ordinal of at least this partition")
+	rootCmd.Flags().StringArrayVar(&volumeClaimTemplateFlags, "volume-claim-template", []string{}, "StatefulSet volume claim template as NAME,mountPath=PATH,size=SIZE[,storageClass=CLASS][,accessMode=MODE], e.g. data,mountPath=/data,size=10Gi (can be repeated)")
+
+	// PodDisruptionBudget flags
+	rootCmd.Flags().BoolVar(&pdbEnabled, "pdb-enabled", false, "Generate PodDisruptionBudgets (on by default in production for components with more than one replica)")
+	rootCmd.Flags().StringVar(&pdbMinAvailable, "pdb-min-available", "", "PDB minAvailable as a number or percentage, e.g. 2 or 50%")
+	rootCmd.Flags().StringVar(&pdbMaxUnavailable, "pdb-max-unavailable", "", "PDB maxUnavailable as a number or percentage (default: 1)")
+
+	// NetworkPolicy flags
+	rootCmd.Flags().BoolVar(&networkPolicyEnabled, "network-policy-enabled", false, "Generate NetworkPolicies")
+	rootCmd.Flags().BoolVar(&networkPolicyDefaultDeny, "network-policy-default-deny", true, "Deny all ingress and egress traffic in the namespace unless allowed")
+	rootCmd.Flags().BoolVar(&networkPolicyAllowSameNamespace, "network-policy-allow-same-namespace", true, "Allow traffic between the app and other pods in the namespace")
+	rootCmd.Flags().BoolVar(&networkPolicyAllowDNS, "network-policy-allow-dns", true, "Allow DNS lookups through kube-dns")
+	rootCmd.Flags().StringVar(&networkPolicyIngressNamespace, "network-policy-ingress-namespace", "", "Namespace of the ingress controller (default: derived from --ingress-class)")
+	rootCmd.Flags().StringArrayVar(&labelFlags, "label", []string{}, "Label KEY=VALUE on every object and pod template, or KIND:KEY=VALUE on objects of a kind, with Pod for pod templates (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&annotationFlags, "annotation", []string{}, "Annotation KEY=VALUE on every object, or KIND:KEY=VALUE on objects of a kind, with Pod for pod templates (can be repeated)")
+	rootCmd.Flags().BoolVar(&recommendedLabels, "recommended-labels", false, "Add the app.kubernetes.io version, part-of, component and managed-by labels")
+	rootCmd.Flags().StringVar(&partOf, "part-of", "", "Value of the app.kubernetes.io/part-of label (default: the app name)")
+	rootCmd.Flags().StringArrayVar(&patchFlags, "patch", []string{}, "Patch file applied to the generated objects as FILE[,type=json|merge|strategic][,kind=KIND][,name=NAME][,env=ENV...] (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&networkPolicyEgressFlags, "network-policy-egress", []string{}, "Allowed egress as NAME,cidr=CIDR[,port=PORT[-END_PORT][/PROTOCOL]], e.g. postgres,cidr=10.0.0.0/16,port=5432 (can be repeated)")
+
+	// Horizontal Pod Autoscaler flags
+	rootCmd.Flags().BoolVar(&hpaEnabled, "hpa-enabled", false, "Enable HorizontalPodAutoscaler")
+	rootCmd.Flags().IntVar(&hpaMinReplicas, "hpa-min-replicas", 1, "HPA minimum replicas")
+	rootCmd.Flags().IntVar(&hpaMaxReplicas, "hpa-max-replicas", 100, "HPA maximum replicas")
+	rootCmd.Flags().IntVar(&hpaCPUTarget, "hpa-cpu-target", 80, "HPA target CPU utilization percentage (0 to disable)")
+	rootCmd.Flags().IntVar(&hpaMemoryTarget, "hpa-memory-target", 80, "HPA target memory utilization percentage (0 to disable)")
+	rootCmd.Flags().IntVar(&hpaScaleUpStabilization, "hpa-scale-up-stabilization", -1, "HPA scale-up stabilization window in seconds (-1 for the Kubernetes default)")
+	rootCmd.Flags().IntVar(&hpaScaleDownStabilization, "hpa-scale-down-stabilization", -1, "HPA scale-down stabilization window in seconds (-1 for the Kubernetes default)")
+	rootCmd.Flags().StringVar(&hpaScaleUpSelectPolicy, "hpa-scale-up-select-policy", "", "HPA scale-up select policy (Max|Min|Disabled)")
+	rootCmd.Flags().StringVar(&hpaScaleDownSelectPolicy, "hpa-scale-down-select-policy", "", "HPA scale-down select policy (Max|Min|Disabled)")
+	rootCmd.Flags().StringArrayVar(&hpaScaleUpPolicies, "hpa-scale-up-policy", []string{}, "HPA scale-up policy as TYPE:VALUE:PERIOD_SECONDS, e.g. Pods:4:60 (can be repeated)")
+	rootCmd.Flags().StringArrayVar(&hpaScaleDownPolicies, "hpa-scale-down-policy", []string{}, "HPA scale-down policy as TYPE:VALUE:PERIOD_SECONDS, e.g. Percent:10:60 (can be repeated)")
+
 	// Output flags
 	rootCmd.Flags().BoolVar(&render, "render", false, "Render manifests to stdout")
 	rootCmd.Flags().StringVar(&outputDir, "output-dir", "", "Output directory for rendered manifests")
-
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
+	rootCmd.Flags().StringVar(&outputFormat, "format", "flat", "Output layout (flat|kustomize|helm)")
+	rootCmd.Flags().BoolVar(&diffMode, "diff", false, "Show a diff against the manifests on disk instead of writing them; exits non-zero if they differ")
+	rootCmd.Flags().StringVar(&manifestEncoding, "output-format", "yaml", "Manifest encoding (yaml|json|ndjson)")
+	rootCmd.Flags().BoolVar(&singleFile, "single-file", false, "Write the manifests of each environment to one file: all.yaml, a JSON List in all.json or all.ndjson")
+	rootCmd.Flags().BoolVar(&helmValuesOnly, "helm-values-only", false, "With --format helm, write only the values-<env>.yaml files for the bundled chart")
+
+	// Validation flags
+	rootCmd.Flags().StringVar(&kubeVersion, "kube-version", defaultKubeVersion, "Kubernetes version the generated manifests are checked against")
+	rootCmd.Flags().BoolVar(&skipValidation, "skip-validation", false, "Skip the self-check of generated manifests")
+
+	rootCmd.AddCommand(newChartCommand())
+	rootCmd.AddCommand(newCheckCommand())
+	rootCmd.AddCommand(newImportCommand())
+	rootCmd.AddCommand(newPresetsCommand())
+	return rootCmd
 }
 
 func promptForInputs() error {
@@ -330,39 +496,16 @@ func promptForInputs() error {
 	}
 
 	// Ask for environment first, then image tags based on selection
-	if env == "" {
-		options := []string{"staging", "production", "both (staging & production)", "skip"}
+	if env == "" && !multiEnvironment() {
+		allOption := fmt.Sprintf("all (%s)", strings.Join(environmentNames, ", "))
+		options := append(append([]string{}, environmentNames...), allOption, "skip")
 		selectedOption, _ := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Environment:")
-		if selectedOption == "both (staging & production)" {
+		if selectedOption == allOption {
 			allEnvironments = true
-			// Prompt for staging-specific image tag
-			if imageTagStage == "" {
-				pterm.Print("Docker image tag for staging (e.g., staging-123): ")
-				input, err := reader.ReadString('\n')
-				if err != nil {
-					return fmt.Errorf("failed to read input: %w", err)
-				}
-				imageTagStage = strings.TrimSpace(input)
-				if imageTagStage == "" {
-					return fmt.Errorf("staging image tag cannot be empty")
-				}
-			}
-			// Prompt for production-specific image tag
-			if imageTagProd == "" {
-				pterm.Print("Docker image tag for production (e.g., production-456): ")
-				input, err := reader.ReadString('\n')
-				if err != nil {
-					return fmt.Errorf("failed to read input: %w", err)
-				}
-				imageTagProd = strings.TrimSpace(input)
-				if imageTagProd == "" {
-					return fmt.Errorf("production image tag cannot be empty")
-				}
-			}
 		} else if selectedOption != "skip" {
 			env = selectedOption
 			// Ask for image tag for single environment
-			if imageTag == "" {
+			if singleEnvironmentTarget(env).imageTag == "" {
 				pterm.Print("Docker image tag (e.g., v1.0.0 or staging-123): ")
 				input, err := reader.ReadString('\n')
 				if err != nil {
@@ -374,9 +517,9 @@ func promptForInputs() error {
 				}
 			}
 		}
-	} else {
+	} else if !multiEnvironment() {
 		// Environment was provided via flag, but image tag might not be
-		if imageTag == "" && !allEnvironments {
+		if singleEnvironmentTarget(env).imageTag == "" {
 			pterm.Print("Docker image tag (e.g., v1.0.0 or staging-123): ")
 			input, err := reader.ReadString('\n')
 			if err != nil {
@@ -389,6 +532,26 @@ func promptForInputs() error {
 		}
 	}
 
+	// Prompt for the environments without an image tag of their own
+	if multiEnvironment() {
+		for _, target := range environmentTargets() {
+			if target.imageTag != "" {
+				continue
+			}
+			pterm.Printf("Docker image tag for %s (e.g., %s-123): ", target.name, target.name)
+			input, err := reader.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("failed to read input: %w", err)
+			}
+			e := resolvedEnvironments[target.name]
+			e.ImageTag = strings.TrimSpace(input)
+			if e.ImageTag == "" {
+				return fmt.Errorf("%s image tag cannot be empty", target.name)
+			}
+			resolvedEnvironments[target.name] = e
+		}
+	}
+
 	if containerPort == 3000 {
 		pterm.Print("Container port (default: 3000, press Enter for default): ")
 		input, err := reader.ReadString('\n')
@@ -428,39 +591,27 @@ func promptForInputs() error {
 		if selectedOption == "yes" {
 			ingressEnabled = true
 
-			if allEnvironments {
-				// Prompt for both staging and production ingress hosts
-				if ingressHostStage == "" {
-					pterm.Print("Ingress host for staging (e.g., stage.example.com): ")
-					input, err := reader.ReadString('\n')
-					if err != nil {
-						return fmt.Errorf("failed to read input: %w", err)
+			if multiEnvironment() {
+				// Prompt for the ingress host and TLS secret of every environment
+				for _, target := range environmentTargets() {
+					e := resolvedEnvironments[target.name]
+					if e.IngressHost == "" {
+						pterm.Printf("Ingress host for %s (e.g., %s.example.com): ", target.name, target.name)
+						input, err := reader.ReadString('\n')
+						if err != nil {
+							return fmt.Errorf("failed to read input: %w", err)
+						}
+						e.IngressHost = strings.TrimSpace(input)
 					}
-					ingressHostStage = strings.TrimSpace(input)
-				}
-				if ingressHostProd == "" {
-					pterm.Print("Ingress host for production (e.g., prod.example.com): ")
-					input, err := reader.ReadString('\n')
-					if err != nil {
-						return fmt.Errorf("failed to read input: %w", err)
+					if e.IngressTLSSecret == "" {
+						pterm.Printf("Ingress TLS secret for %s (press Enter to skip): ", target.name)
+						input, err := reader.ReadString('\n')
+						if err != nil {
+							return fmt.Errorf("failed to read input: %w", err)
+						}
+						e.IngressTLSSecret = strings.TrimSpace(input)
 					}
-					ingressHostProd = strings.TrimSpace(input)
-				}
-				if ingressTLSSecretStage == "" {
-					pterm.Print("Ingress TLS secret for staging (press Enter to skip): ")
-					input, err := reader.ReadString('\n')
-					if err != nil {
-						return fmt.Errorf("failed to read input: %w", err)
-					}
-					ingressTLSSecretStage = strings.TrimSpace(input)
-				}
-				if ingressTLSSecretProd == "" {
-					pterm.Print("Ingress TLS secret for production (press Enter to skip): ")
-					input, err := reader.ReadString('\n')
-					if err != nil {
-						return fmt.Errorf("failed to read input: %w", err)
-					}
-					ingressTLSSecretProd = strings.TrimSpace(input)
+					resolvedEnvironments[target.name] = e
 				}
 			} else {
 				// Single environment - prompt for regular ingress host
@@ -507,9 +658,39 @@ func promptForInputs() error {
 }
 
 func run(cmd *cobra.Command, args []string) error {
+	// Load config file values for any flag not set on the command line
+	var cfg *AppConfig
+	if configFile != "" {
+		var err error
+		if cfg, err = loadConfig(configFile); err != nil {
+			return err
+		}
+		a := configApplier{changed: cmd.Flags().Changed}
+		a.str("preset", &presetName, cfg.Preset)
+		a.str("preset-dir", &presetDir, cfg.PresetDir)
+	}
+
+	// The preset fills in what neither the flags nor the config file set
+	if err := applyPreset(cmd.Flags().Changed); err != nil {
+		return err
+	}
+	if cfg != nil {
+		applyConfig(cfg, cmd.Flags().Changed)
+	}
+	if err := resolveEnvironments(cmd.Flags().Changed); err != nil {
+		return err
+	}
+
 	// Check if required values are provided
 	// If any required value is missing, prompt interactively
-	requiredFlagsProvided := appName != "" && imageRepo != "" && imageTag != ""
+	tagsProvided := singleEnvironmentTarget(env).imageTag != ""
+	if multiEnvironment() {
+		tagsProvided = true
+		for _, target := range environmentTargets() {
+			tagsProvided = tagsProvided && target.imageTag != ""
+		}
+	}
+	requiredFlagsProvided := appName != "" && imageRepo != "" && tagsProvided
 
 	// If required flags not provided, prompt for input interactively
 	if !requiredFlagsProvided {
@@ -527,19 +708,103 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 	
 	// Validate image tags based on environment selection
-	if allEnvironments {
-		if imageTagStage == "" {
-			return fmt.Errorf("staging image tag is required (use --image-tag-stage or provide in interactive mode)")
-		}
-		if imageTagProd == "" {
-			return fmt.Errorf("production image tag is required (use --image-tag-prod or provide in interactive mode)")
+	if multiEnvironment() {
+		for _, target := range environmentTargets() {
+			if target.imageTag == "" {
+				return fmt.Errorf("%s image tag is required (set environments.%s.imageTag, use --environment-config %s,imageTag=TAG or --image-tag)", target.name, target.name, target.name)
+			}
 		}
 	} else {
-		if imageTag == "" {
+		if singleEnvironmentTarget(env).imageTag == "" {
 			return fmt.Errorf("image tag is required")
 		}
 	}
 
+	if err := resolveProbes(); err != nil {
+		return err
+	}
+	if secretDataEncoding != "stringData" && secretDataEncoding != "base64" {
+		return fmt.Errorf("invalid --secret-data-encoding %q (expected stringData or base64)", secretDataEncoding)
+	}
+	if err := resolveSecretFormat(); err != nil {
+		return err
+	}
+	if err := resolveEnvVars(); err != nil {
+		return err
+	}
+	if err := resolveScheduling(); err != nil {
+		return err
+	}
+	if err := resolveStatefulSet(); err != nil {
+		return err
+	}
+	if err := resolveComponents(); err != nil {
+		return err
+	}
+	if err := resolveJobs(); err != nil {
+		return err
+	}
+	if err := resolvePersistentVolumeClaims(); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("pdb-enabled") {
+		pdbEnabledSet = true
+	}
+	if err := resolvePDB(); err != nil {
+		return err
+	}
+	if err := resolveNetworkPolicy(); err != nil {
+		return err
+	}
+	if err := resolveLabels(); err != nil {
+		return err
+	}
+	if err := resolvePatches(); err != nil {
+		return err
+	}
+
+	// HPA and VPA in Auto mode fight over the same pods
+	if hpaEnabled && vpaEnabled {
+		warnf("HPA and VPA (updateMode: Auto) both target %s %s; they will compete when scaling on CPU/memory", primaryComponent().workloadKind(), primaryComponent().deploymentName())
+	}
+
+	if err := resolveOutputEncoding(); err != nil {
+		// The message names the conflicting flags
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		return err
+	}
+
+	// Compare with the files on disk instead of writing them
+	if diffMode {
+		if outputFormat != "flat" {
+			return fmt.Errorf("--diff only supports --format flat")
+		}
+		return runDiff(cmd)
+	}
+
+	// Manifests that fail validation are not a usage error
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+
+	// Kustomize and Helm write a directory layout instead of flat directories
+	switch outputFormat {
+	case "flat":
+	case "kustomize", "helm":
+		rootDir := appName
+		if outputDir != "" {
+			rootDir = outputDir
+		} else if render {
+			return fmt.Errorf("--format %s writes a directory layout; use --output-dir instead of rendering to stdout", outputFormat)
+		}
+		if outputFormat == "helm" {
+			return createHelmLayout(rootDir, helmValuesOnly)
+		}
+		return createKustomizeLayout(rootDir)
+	default:
+		return fmt.Errorf("invalid --format %q (expected flat, kustomize or helm)", outputFormat)
+	}
+
 	// Handle different output modes
 	if render {
 		if outputDir != "" {
@@ -550,7 +815,7 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 
 	// Default behavior: create folder structure with files
-	if !allEnvironments {
+	if !multiEnvironment() {
 		return createManifestFiles(env)
 	}
 
@@ -559,19 +824,17 @@ func run(cmd *cobra.Command, args []string) error {
 
 // Generate manifests directly to stdout
 func generateManifestsToStdout() error {
-	manifests, err := generateManifests(env, imageTag, namespace, ingressHost, ingressTLSSecret)
+	target := singleEnvironmentTarget(env)
+	manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
 	if err != nil {
 		return err
 	}
 
-	for _, manifest := range manifests {
-		fmt.Println("---")
-		data, err := yaml.Marshal(manifest)
-		if err != nil {
-			return fmt.Errorf("failed to marshal YAML: %w", err)
-		}
-		fmt.Print(string(data))
+	data, err := encodeManifests(manifests)
+	if err != nil {
+		return err
 	}
+	fmt.Print(string(data))
 	return nil
 }
 
@@ -581,18 +844,15 @@ func generateManifestsToDir(outputDir string) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	manifests, err := generateManifests(env, imageTag, namespace, ingressHost, ingressTLSSecret)
+	target := singleEnvironmentTarget(env)
+	manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
 	if err != nil {
 		return err
 	}
 
-	var filesCreated []string
-	for _, manifest := range manifests {
-		filename, err := writeManifestToFile(manifest, outputDir)
-		if err != nil {
-			return err
-		}
-		filesCreated = append(filesCreated, filename)
+	filesCreated, err := writeManifests(manifests, outputDir)
+	if err != nil {
+		return err
 	}
 
 	pterm.Success.Printf("Created %d manifest files in directory: %s\n", len(filesCreated), outputDir)
@@ -602,17 +862,9 @@ func generateManifestsToDir(outputDir string) error {
 // Create manifest files in app directory
 func createManifestFiles(envName string) error {
 	// Determine values based on environment
-	tag := imageTag
-	ns := namespace
-	host := ingressHost
-	tlsSecret := ingressTLSSecret
-
-	// Use environment-specific namespace if not provided
-	if ns == "" && envName != "" {
-		ns = fmt.Sprintf("%s-%s", appName, envName)
-	}
+	target := singleEnvironmentTarget(envName)
 
-	manifests, err := generateManifests(envName, tag, ns, host, tlsSecret)
+	manifests, err := generateManifests(target.name, target.imageTag, target.namespace, target.ingressHost, target.tlsSecret)
 	if err != nil {
 		return err
 	}
@@ -623,13 +875,9 @@ func createManifestFiles(envName string) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	var filesCreated []string
-	for _, manifest := range manifests {
-		filename, err := writeManifestToFile(manifest, outputDir)
-		if err != nil {
-			return err
-		}
-		filesCreated = append(filesCreated, filename)
+	filesCreated, err := writeManifests(manifests, outputDir)
+	if err != nil {
+		return err
 	}
 
 	pterm.Success.Printf("Created %d manifest files in directory: %s\n", len(filesCreated), outputDir)
@@ -643,28 +891,7 @@ func createManifestFiles(envName string) error {
 
 // Create manifest files for all environments
 func createManifestFilesForAllEnvironments() error {
-	environments := []struct {
-		name         string
-		imageTag     string
-		namespace    string
-		ingressHost  string
-		tlsSecret    string
-	}{
-		{
-			name:         "staging",
-			imageTag:     imageTagStage,
-			namespace:    fmt.Sprintf("%s-staging", appName),
-			ingressHost:  ingressHostStage,
-			tlsSecret:    ingressTLSSecretStage,
-		},
-		{
-			name:         "production",
-			imageTag:     imageTagProd,
-			namespace:    fmt.Sprintf("%s-production", appName),
-			ingressHost:  ingressHostProd,
-			tlsSecret:    ingressTLSSecretProd,
-		},
-	}
+	environments := environmentTargets()
 
 	// Create base directory
 	baseDir := appName
@@ -691,13 +918,9 @@ func createManifestFilesForAllEnvironments() error {
 		}
 
 		// Write manifests to environment directory
-		var filesCreated []string
-		for _, manifest := range manifests {
-			filename, err := writeManifestToFile(manifest, envDir)
-			if err != nil {
-				return fmt.Errorf("failed to write manifest for %s: %w", envConfig.name, err)
-			}
-			filesCreated = append(filesCreated, filename)
+		filesCreated, err := writeManifests(manifests, envDir)
+		if err != nil {
+			return fmt.Errorf("failed to write manifest for %s: %w", envConfig.name, err)
 		}
 
 		pterm.Success.Printf("  ✓ %s environment manifests created in %s (%d files)\n", envConfig.name, envDir, len(filesCreated))
@@ -707,8 +930,13 @@ func createManifestFilesForAllEnvironments() error {
 	pterm.Success.Printf("All environments generated successfully!\n")
 	pterm.Info.Printf("Directory structure:\n")
 	pterm.Printf("  %s/\n", baseDir)
-	pterm.Printf("    ├── staging/\n")
-	pterm.Printf("    └── production/\n")
+	for i, envConfig := range environments {
+		branch := "├──"
+		if i == len(environments)-1 {
+			branch = "└──"
+		}
+		pterm.Printf("    %s %s/\n", branch, envConfig.name)
+	}
 
 	return nil
 }
@@ -717,9 +945,13 @@ func createManifestFilesForAllEnvironments() error {
 func generateManifests(envName, tag, ns, ingressHostVal, tlsSecretVal string) ([]interface{}, error) {
 	var manifests []interface{}
 
+	// Split env vars into ConfigMap data, Secret data and direct container env
+	configData, secretData, containerEnv := splitEnvVars(envVarsFor(envName))
+
 	// Determine if we should enable ConfigMap and Secret
-	enableConfigMap := envName == "staging" || envName == "production"
-	enableIngress := ingressEnabled && (ingressHostVal != "" || allEnvironments)
+	enableConfigMap := envName != "" || len(configData) > 0
+	enableSecret := envName != "" || len(secretData) > 0
+	enableIngress := ingressEnabled && ingressHostVal != ""
 
 	// Namespace
 	if ns != "" {
@@ -739,30 +971,86 @@ func generateManifests(envName, tag, ns, ingressHostVal, tlsSecretVal string) ([
 
 	// ConfigMap
 	if enableConfigMap {
-		configMap := createConfigMap(envName, ns)
+		configMap := createConfigMap(envName, ns, configData)
 		manifests = append(manifests, configMap)
 	}
 
 	// Secret
-	if enableConfigMap {
-		secret := createSecret(envName, ns)
+	if enableSecret {
+		secret, err := createSecretManifest(envName, ns, secretData)
+		if err != nil {
+			return nil, err
+		}
 		manifests = append(manifests, secret)
 	}
 
-	// Deployment
-	deployment := createDeployment(tag, ns, enableConfigMap)
-	manifests = append(manifests, deployment)
+	// PersistentVolumeClaims for the pod volumes
+	for _, p := range pvcConfigs {
+		pvc, err := createPersistentVolumeClaim(p, envName, ns)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, pvc)
+	}
+
+	// Deployments and StatefulSets, with the environment's replicas and resources
+	for _, c := range componentsFor(envName) {
+		if c.Workload == "statefulset" {
+			statefulSet := createStatefulSet(c, tag, ns, enableConfigMap, enableSecret, containerEnv)
+			manifests = append(manifests, statefulSet)
+			continue
+		}
+		deployment := createDeployment(c, tag, ns, enableConfigMap, enableSecret, containerEnv)
+		manifests = append(manifests, deployment)
+	}
 
-	// Service
-	service := createService(ns)
-	manifests = append(manifests, service)
+	// Services for the components that expose a port
+	exposed := exposedComponents()
+	for _, c := range exposed {
+		service := createService(c, ns)
+		manifests = append(manifests, service)
+	}
 
-	// Ingress
-	if enableIngress && ingressHostVal != "" {
-		ingress := createIngress(ingressHostVal, tlsSecretVal, ns)
+	// Headless Services governing the StatefulSets
+	for _, c := range resolvedComponents {
+		if c.Workload == "statefulset" {
+			manifests = append(manifests, createHeadlessService(c, ns))
+		}
+	}
+
+	// Ingress to the first exposed component
+	if enableIngress {
+		if len(exposed) == 0 {
+			return nil, fmt.Errorf("ingress requires a component that exposes a port")
+		}
+		ingress := createIngress(ingressHostVal, tlsSecretVal, ns, serviceName(exposed[0]))
 		manifests = append(manifests, ingress)
 	}
 
+	// Jobs and CronJobs running the app image
+	for _, j := range jobConfigs {
+		if !j.isCron() {
+			manifests = append(manifests, createJob(j, envName, tag, ns, enableConfigMap, enableSecret, containerEnv))
+			continue
+		}
+		cronJob, err := createCronJob(j, envName, tag, ns, enableConfigMap, enableSecret, containerEnv)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, cronJob)
+	}
+
+	// NetworkPolicies; the ingress controller is only let in when there is an Ingress
+	if networkPolicyEnabled {
+		policies, err := createNetworkPolicies(ns, enableIngress)
+		if err != nil {
+			return nil, err
+		}
+		for _, policy := range policies {
+			manifests = append(manifests, policy)
+		}
+	}
+
 	// ResourceQuota
 	if resourceQuotaEnabled {
 		quota := createResourceQuota(ns)
@@ -775,6 +1063,42 @@ func generateManifests(envName, tag, ns, ingressHostVal, tlsSecretVal string) ([
 		manifests = append(manifests, vpa)
 	}
 
+	// PodDisruptionBudgets
+	if pdbEnabledFor(envName) {
+		pdbs, err := createPDBs(envName, ns)
+		if err != nil {
+			return nil, err
+		}
+		for _, pdb := range pdbs {
+			manifests = append(manifests, pdb)
+		}
+	}
+
+	// HPA
+	if hpaEnabled {
+		hpa, err := createHPA(envName, ns)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, hpa)
+	}
+
+	// Labels and annotations
+	if err := applyLabels(manifests, envName, tag); err != nil {
+		return nil, err
+	}
+
+	// User patches
+	manifests, err := applyPatches(manifests, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every object against the Kubernetes schemas
+	if err := validateManifests(manifests, envName); err != nil {
+		return nil, err
+	}
+
 	return manifests, nil
 }
 
@@ -802,9 +1126,13 @@ func createServiceAccount(name, ns string) *ServiceAccount {
 }
 
 // Create ConfigMap resource
-func createConfigMap(envName, ns string) *ConfigMap {
-	data := map[string]string{
-		"APP_ENV": envName,
+func createConfigMap(envName, ns string, values map[string]string) *ConfigMap {
+	data := map[string]string{}
+	if envName != "" {
+		data["APP_ENV"] = envName
+	}
+	for key, value := range values {
+		data[key] = value
 	}
 	return &ConfigMap{
 		APIVersion: "v1",
@@ -818,11 +1146,15 @@ func createConfigMap(envName, ns string) *ConfigMap {
 }
 
 // Create Secret resource
-func createSecret(envName, ns string) *Secret {
+func createSecret(envName, ns string, values map[string]string) *Secret {
+	// Placeholder key to fill in when no secret values were given
 	stringData := map[string]string{
 		"APP_KEY": "",
 	}
-	return &Secret{
+	if len(values) > 0 {
+		stringData = values
+	}
+	secret := &Secret{
 		APIVersion: "v1",
 		Kind:       "Secret",
 		Metadata: Metadata{
@@ -832,105 +1164,43 @@ func createSecret(envName, ns string) *Secret {
 		Type:       "Opaque",
 		StringData: stringData,
 	}
-}
-
-// Create Deployment resource
-func createDeployment(tag, ns string, useEnvFrom bool) *Deployment {
-	// Selector labels are required for Deployment selector and pod template
-	selectorLabels := map[string]string{
-		"app.kubernetes.io/name":     "k8s-config-generator",
-		"app.kubernetes.io/instance": appName,
-		"tier":                       "webserver",
-		"layer":                      "node",
-	}
-
-	replicasInt32 := int32(replicas)
-
-	// Build image pull secrets
-	var imagePullSecretsRefs []ImagePullSecretRef
-	for _, secret := range imagePullSecrets {
-		imagePullSecretsRefs = append(imagePullSecretsRefs, ImagePullSecretRef{Name: secret})
-	}
 
-	// Build container
-	container := Container{
-		Name:            fmt.Sprintf("%s-node", appName),
-		Image:           fmt.Sprintf("%s:%s", imageRepo, tag),
-		ImagePullPolicy: "IfNotPresent",
-		Ports: []ContainerPort{
-			{
-				Name:          "http-port",
-				ContainerPort: containerPort,
-				Protocol:      "TCP",
-			},
-		},
-		SecurityContext: map[string]interface{}{
-			"allowPrivilegeEscalation": false,
-			"capabilities": map[string]interface{}{
-				"drop": []string{"ALL"},
-			},
-			"privileged": false,
-		},
-	}
-
-	// Add envFrom if ConfigMap/Secret are enabled
-	if useEnvFrom {
-		container.EnvFrom = []map[string]interface{}{
-			{
-				"configMapRef": map[string]string{
-					"name": appName,
-				},
-			},
-			{
-				"secretRef": map[string]string{
-					"name": appName,
-				},
-			},
+	// Optionally write base64-encoded data instead of stringData
+	if secretDataEncoding == "base64" {
+		secret.Data = make(map[string]string, len(stringData))
+		for key, value := range stringData {
+			secret.Data[key] = base64.StdEncoding.EncodeToString([]byte(value))
 		}
+		secret.StringData = nil
 	}
 
-	// Add resources if provided
-	if resourceRequestsCPU != "" || resourceRequestsMemory != "" || resourceLimitsCPU != "" || resourceLimitsMemory != "" {
-		resources := make(map[string]interface{})
-		requests := make(map[string]string)
-		limits := make(map[string]string)
+	return secret
+}
 
-		if resourceRequestsCPU != "" {
-			requests["cpu"] = resourceRequestsCPU
-		}
-		if resourceRequestsMemory != "" {
-			requests["memory"] = resourceRequestsMemory
-		}
-		if resourceLimitsCPU != "" {
-			limits["cpu"] = resourceLimitsCPU
-		}
-		if resourceLimitsMemory != "" {
-			limits["memory"] = resourceLimitsMemory
-		}
+// Create Deployment resource for a component
+func createDeployment(c ComponentConfig, tag, ns string, useConfigMap, useSecret bool, env []EnvVar) *Deployment {
+	// Selector labels are required for Deployment selector and pod template
+	selectorLabels := c.selectorLabels()
 
-		if len(requests) > 0 {
-			resources["requests"] = requests
-		}
-		if len(limits) > 0 {
-			resources["limits"] = limits
-		}
-		container.Resources = resources
+	// Replicas are managed by the HPA when it is enabled
+	var replicasPtr *int32
+	if !hpaEnabled || c.Name != primaryComponent().Name {
+		replicasInt32 := int32(*c.Replicas)
+		replicasPtr = &replicasInt32
 	}
 
-	saName := appName
-	if serviceAccount != "" {
-		saName = serviceAccount
-	}
+	podSpec := createPodSpec(c, tag, useConfigMap, useSecret, env)
+	applyScheduling(&podSpec, selectorLabels)
 
 	deployment := &Deployment{
 		APIVersion: "apps/v1",
 		Kind:       "Deployment",
 		Metadata: Metadata{
-			Name:      fmt.Sprintf("%s-node", appName),
+			Name:      c.deploymentName(),
 			Namespace: ns,
 		},
 		Spec: DeploymentSpec{
-			Replicas: &replicasInt32,
+			Replicas: replicasPtr,
 			Selector: Selector{
 				MatchLabels: selectorLabels,
 			},
@@ -945,11 +1215,7 @@ func createDeployment(tag, ns string, useEnvFrom bool) *Deployment {
 				Metadata: Metadata{
 					Labels: selectorLabels,
 				},
-				Spec: PodSpec{
-					ServiceAccountName: saName,
-					ImagePullSecrets:   imagePullSecretsRefs,
-					Containers:         []Container{container},
-				},
+				Spec: podSpec,
 			},
 		},
 	}
@@ -957,21 +1223,136 @@ func createDeployment(tag, ns string, useEnvFrom bool) *Deployment {
 	return deployment
 }
 
-// Create Service resource
-func createService(ns string) *Service {
-	// Selector labels are required for Service selector
-	selectorLabels := map[string]string{
-		"app.kubernetes.io/name":     "k8s-config-generator",
-		"app.kubernetes.io/instance": appName,
-		"tier":                       "webserver",
-		"layer":                      "node",
+// Pod spec of a component: the app container with the image, envFrom,
+// service account and pull secrets, plus its sidecars and init containers
+func createPodSpec(c ComponentConfig, tag string, useConfigMap, useSecret bool, env []EnvVar) PodSpec {
+	// Build image pull secrets
+	var imagePullSecretsRefs []ImagePullSecretRef
+	for _, secret := range imagePullSecrets {
+		imagePullSecretsRefs = append(imagePullSecretsRefs, ImagePullSecretRef{Name: secret})
+	}
+
+	// Build container
+	container := Container{
+		Name:            c.deploymentName(),
+		Image:           fmt.Sprintf("%s:%s", imageRepo, tag),
+		ImagePullPolicy: "IfNotPresent",
+		Command:         c.Command,
+		Args:            c.Args,
+		SecurityContext: appSecurityContext(),
+		Env:             env,
+		VolumeMounts:    c.VolumeMounts,
+	}
+
+	// Only components exposing a port get it and the probes checking it
+	if *c.Port > 0 {
+		container.Ports = []ContainerPort{
+			{
+				Name:          appPortName(),
+				ContainerPort: *c.Port,
+				Protocol:      "TCP",
+			},
+		}
+		container.LivenessProbe = buildProbe(livenessProbeConfig, *c.Port)
+		container.ReadinessProbe = buildProbe(readinessProbeConfig, *c.Port)
+		container.StartupProbe = buildProbe(startupProbeConfig, *c.Port)
+	}
+
+	// Add envFrom if ConfigMap/Secret are enabled
+	envFrom := envFromSources(useConfigMap, useSecret)
+	container.EnvFrom = envFrom
+
+	// Add resources if provided
+	container.Resources = resourceRequirements(c.Resources)
+
+	saName := appName
+	if serviceAccount != "" {
+		saName = serviceAccount
+	}
+
+	// Sidecars run next to the app container, init containers before it
+	return PodSpec{
+		ServiceAccountName: saName,
+		ImagePullSecrets:   imagePullSecretsRefs,
+		SecurityContext:    podSecurityContext(),
+		InitContainers:     buildContainers(c.InitContainers, envFrom),
+		Containers:         append([]Container{container}, buildContainers(c.Sidecars, envFrom)...),
+		Volumes:            buildVolumes(c.Volumes),
 	}
+}
+
+// Security context shared by every container we generate
+func containerSecurityContext() map[string]interface{} {
+	return map[string]interface{}{
+		"allowPrivilegeEscalation": false,
+		"capabilities": map[string]interface{}{
+			"drop": []string{"ALL"},
+		},
+		"privileged": false,
+	}
+}
+
+// envFrom entries loading the app ConfigMap and Secret
+func envFromSources(useConfigMap, useSecret bool) []map[string]interface{} {
+	var envFrom []map[string]interface{}
+	if useConfigMap {
+		envFrom = append(envFrom, map[string]interface{}{
+			"configMapRef": map[string]string{
+				"name": appName,
+			},
+		})
+	}
+	if useSecret {
+		envFrom = append(envFrom, map[string]interface{}{
+			"secretRef": map[string]string{
+				"name": appName,
+			},
+		})
+	}
+	return envFrom
+}
+
+// Container resources, or nil if none are set
+func resourceRequirements(section ResourcesSection) map[string]interface{} {
+	requests := make(map[string]string)
+	limits := make(map[string]string)
+
+	if section.Requests.CPU != "" {
+		requests["cpu"] = section.Requests.CPU
+	}
+	if section.Requests.Memory != "" {
+		requests["memory"] = section.Requests.Memory
+	}
+	if section.Limits.CPU != "" {
+		limits["cpu"] = section.Limits.CPU
+	}
+	if section.Limits.Memory != "" {
+		limits["memory"] = section.Limits.Memory
+	}
+
+	if len(requests) == 0 && len(limits) == 0 {
+		return nil
+	}
+	resources := make(map[string]interface{})
+	if len(requests) > 0 {
+		resources["requests"] = requests
+	}
+	if len(limits) > 0 {
+		resources["limits"] = limits
+	}
+	return resources
+}
+
+// Create Service resource for a component that exposes a port
+func createService(c ComponentConfig, ns string) *Service {
+	// Selector labels are required for Service selector
+	selectorLabels := c.selectorLabels()
 
 	return &Service{
 		APIVersion: "v1",
 		Kind:       "Service",
 		Metadata: Metadata{
-			Name:      appName,
+			Name:      serviceName(c),
 			Namespace: ns,
 		},
 		Spec: ServiceSpec{
@@ -989,8 +1370,8 @@ func createService(ns string) *Service {
 	}
 }
 
-// Create Ingress resource
-func createIngress(host, tlsSecret, ns string) *Ingress {
+// Create Ingress resource routing to a Service
+func createIngress(host, tlsSecret, ns, service string) *Ingress {
 	ingress := &Ingress{
 		APIVersion: "networking.k8s.io/v1",
 		Kind:       "Ingress",
@@ -1010,7 +1391,7 @@ func createIngress(host, tlsSecret, ns string) *Ingress {
 								PathType: "Prefix",
 								Backend: IngressPathBackend{
 									Service: IngressService{
-										Name: appName,
+										Name: service,
 										Port: IngressServicePort{
 											Number: 80,
 										},
@@ -1065,14 +1446,14 @@ func createVPA(ns string) *VPA {
 		APIVersion: "autoscaling.k8s.io/v1beta2",
 		Kind:       "VerticalPodAutoscaler",
 		Metadata: Metadata{
-			Name:      fmt.Sprintf("%s-node-vpa", appName),
+			Name:      fmt.Sprintf("%s-vpa", primaryComponent().deploymentName()),
 			Namespace: ns,
 		},
 		Spec: VPASpec{
 			TargetRef: VPATargetRef{
 				APIVersion: "apps/v1",
-				Kind:       "Deployment",
-				Name:       fmt.Sprintf("%s-node", appName),
+				Kind:       primaryComponent().workloadKind(),
+				Name:       primaryComponent().deploymentName(),
 			},
 			UpdatePolicy: map[string]string{
 				"updateMode": "Auto",
@@ -1083,9 +1464,37 @@ func createVPA(ns string) *VPA {
 
 // Write manifest to file and return filename
 func writeManifestToFile(manifest interface{}, outputDir string) (string, error) {
+	filename := manifestFileName(manifest)
+	filePath := filepath.Join(outputDir, filename)
+
+	// Marshal to YAML or JSON
+	data, err := encodeManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	// Write file
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+
+	return filename, nil
+}
+
+// File name of a manifest: <short kind>-<name>.yaml, or .json
+func manifestFileName(manifest interface{}) string {
 	// Get kind and name from manifest
-	var kind, name string
-	
+	kind, name := manifestKindName(manifest)
+
+	// Generate filename
+	cleanName := strings.ToLower(name)
+	cleanName = strings.ReplaceAll(cleanName, "/", "-")
+	cleanName = strings.ReplaceAll(cleanName, ":", "-")
+	return fmt.Sprintf("%s-%s%s", kind, cleanName, manifestExtension())
+}
+
+// Short kind (as used in file names) and name of a manifest
+func manifestKindName(manifest interface{}) (kind, name string) {
 	switch m := manifest.(type) {
 	case *Namespace:
 		kind = "namespace"
@@ -1099,44 +1508,122 @@ func writeManifestToFile(manifest interface{}, outputDir string) (string, error)
 	case *Secret:
 		kind = "secret"
 		name = m.Metadata.Name
+	case *SopsSecret:
+		kind = "secret"
+		name = m.Secret.Metadata.Name
+	case *SealedSecret:
+		kind = "sealedsecret"
+		name = m.Metadata.Name
+	case *ExternalSecret:
+		kind = "externalsecret"
+		name = m.Metadata.Name
+	case *PersistentVolumeClaim:
+		kind = "pvc"
+		name = m.Metadata.Name
 	case *Deployment:
 		kind = "deployment"
 		name = m.Metadata.Name
+	case *StatefulSet:
+		kind = "statefulset"
+		name = m.Metadata.Name
 	case *Service:
 		kind = "service"
 		name = m.Metadata.Name
 	case *Ingress:
 		kind = "ingress"
 		name = m.Metadata.Name
+	case *Job:
+		kind = "job"
+		name = m.Metadata.Name
+	case *CronJob:
+		kind = "cronjob"
+		name = m.Metadata.Name
+	case *NetworkPolicy:
+		kind = "networkpolicy"
+		name = m.Metadata.Name
 	case *ResourceQuota:
 		kind = "resourcequota"
 		name = m.Metadata.Name
 	case *VPA:
 		kind = "vpa"
 		name = m.Metadata.Name
+	case *HPA:
+		kind = "hpa"
+		name = m.Metadata.Name
+	case *PodDisruptionBudget:
+		kind = "pdb"
+		name = m.Metadata.Name
+	case *PatchedObject:
+		kind = fileKind(m.kind())
+		name = m.name()
 	default:
 		kind = "manifest"
 		name = "unknown"
 	}
+	return kind, name
+}
 
-	// Generate filename
-	cleanName := strings.ToLower(name)
-	cleanName = strings.ReplaceAll(cleanName, "/", "-")
-	cleanName = strings.ReplaceAll(cleanName, ":", "-")
-	filename := fmt.Sprintf("%s-%s.yaml", kind, cleanName)
-
-	filePath := filepath.Join(outputDir, filename)
-
-	// Marshal to YAML
-	data, err := yaml.Marshal(manifest)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal YAML: %w", err)
+// Short kind used in file names for a full Kubernetes kind
+func fileKind(kind string) string {
+	switch kind {
+	case "VerticalPodAutoscaler":
+		return "vpa"
+	case "HorizontalPodAutoscaler":
+		return "hpa"
+	case "PodDisruptionBudget":
+		return "pdb"
+	case "PersistentVolumeClaim":
+		return "pvc"
 	}
+	return strings.ToLower(kind)
+}
 
-	// Write file
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		return "", fmt.Errorf("failed to write file %s: %w", filePath, err)
+// Metadata of a generated manifest
+func objectMeta(manifest interface{}) *Metadata {
+	switch m := manifest.(type) {
+	case *Namespace:
+		return &m.Metadata
+	case *ServiceAccount:
+		return &m.Metadata
+	case *ConfigMap:
+		return &m.Metadata
+	case *Secret:
+		return &m.Metadata
+	case *SopsSecret:
+		return &m.Secret.Metadata
+	case *SealedSecret:
+		return &m.Metadata
+	case *ExternalSecret:
+		return &m.Metadata
+	case *PersistentVolumeClaim:
+		return &m.Metadata
+	case *Deployment:
+		return &m.Metadata
+	case *StatefulSet:
+		return &m.Metadata
+	case *Service:
+		return &m.Metadata
+	case *Ingress:
+		return &m.Metadata
+	case *Job:
+		return &m.Metadata
+	case *CronJob:
+		return &m.Metadata
+	case *NetworkPolicy:
+		return &m.Metadata
+	case *ResourceQuota:
+		return &m.Metadata
+	case *VPA:
+		return &m.Metadata
+	case *HPA:
+		return &m.Metadata
+	case *PodDisruptionBudget:
+		return &m.Metadata
 	}
+	return nil
+}
 
-	return filename, nil
+// Print a warning to stderr so it never mixes with rendered manifests
+func warnf(format string, args ...interface{}) {
+	pterm.Warning.WithWriter(os.Stderr).Printfln(format, args...)
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type NetworkPolicy struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   Metadata          `yaml:"metadata"`
+	Spec       NetworkPolicySpec `yaml:"spec"`
+}
+
+type NetworkPolicySpec struct {
+	PodSelector PeerSelector        `yaml:"podSelector"`
+	PolicyTypes []string            `yaml:"policyTypes"`
+	Ingress     []NetworkPolicyRule `yaml:"ingress,omitempty"`
+	Egress      []NetworkPolicyRule `yaml:"egress,omitempty"`
+}
+
+// PeerSelector is a label selector that renders as {} when it selects everything
+type PeerSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels,omitempty"`
+}
+
+type NetworkPolicyRule struct {
+	From  []NetworkPolicyPeer `yaml:"from,omitempty"`
+	To    []NetworkPolicyPeer `yaml:"to,omitempty"`
+	Ports []NetworkPolicyPort `yaml:"ports,omitempty"`
+}
+
+type NetworkPolicyPeer struct {
+	NamespaceSelector *PeerSelector `yaml:"namespaceSelector,omitempty"`
+	PodSelector       *PeerSelector `yaml:"podSelector,omitempty"`
+	IPBlock           *IPBlock      `yaml:"ipBlock,omitempty"`
+}
+
+type IPBlock struct {
+	CIDR string `yaml:"cidr"`
+}
+
+type NetworkPolicyPort struct {
+	Protocol string      `yaml:"protocol"`
+	Port     interface{} `yaml:"port,omitempty"`
+	EndPort  *int        `yaml:"endPort,omitempty"`
+}
+
+// NetworkPolicySection configures the NetworkPolicies in the config file
+type NetworkPolicySection struct {
+	Enabled            *bool                   `yaml:"enabled"`
+	DefaultDeny        *bool                   `yaml:"defaultDeny"`
+	AllowSameNamespace *bool                   `yaml:"allowSameNamespace"`
+	AllowDNS           *bool                   `yaml:"allowDNS"`
+	IngressController  IngressControllerConfig `yaml:"ingressController"`
+	Egress             []EgressRuleConfig      `yaml:"egress"`
+}
+
+// IngressControllerConfig locates the pods of the ingress controller; the
+// namespace and labels default to the ones of the ingress class
+type IngressControllerConfig struct {
+	Namespace string            `yaml:"namespace"`
+	PodLabels map[string]string `yaml:"podLabels"`
+}
+
+// EgressRuleConfig allows traffic to CIDRs, optionally only on some ports
+// given as PORT[-END_PORT][/PROTOCOL]
+type EgressRuleConfig struct {
+	Name  string   `yaml:"name"`
+	CIDRs []string `yaml:"cidrs"`
+	Ports []string `yaml:"ports"`
+}
+
+var (
+	networkPolicyEnabled            bool
+	networkPolicyDefaultDeny        bool
+	networkPolicyAllowSameNamespace bool
+	networkPolicyAllowDNS           bool
+	networkPolicyIngressNamespace   string
+	networkPolicyEgressFlags        []string
+	networkPolicyIngressLabels      map[string]string
+	networkPolicyEgressRules        []EgressRuleConfig
+)
+
+// Egress rules from the config file with the flags merged on top
+var resolvedEgressRules []EgressRuleConfig
+
+// Where the controllers of the common ingress classes run, as installed by
+// their official Helm charts
+var ingressControllers = map[string]IngressControllerConfig{
+	"nginx":   {Namespace: "ingress-nginx", PodLabels: map[string]string{"app.kubernetes.io/name": "ingress-nginx"}},
+	"traefik": {Namespace: "traefik", PodLabels: map[string]string{"app.kubernetes.io/name": "traefik"}},
+	"haproxy": {Namespace: "haproxy-controller", PodLabels: map[string]string{"app.kubernetes.io/name": "kubernetes-ingress"}},
+}
+
+// Check the NetworkPolicy settings and merge the --network-policy-egress
+// flags on top of the egress rules from the config file
+func resolveNetworkPolicy() error {
+	resolvedEgressRules = append([]EgressRuleConfig(nil), networkPolicyEgressRules...)
+	for _, spec := range networkPolicyEgressFlags {
+		rule, err := parseEgressRule(spec)
+		if err != nil {
+			return fmt.Errorf("invalid --network-policy-egress %q: %w", spec, err)
+		}
+		replaced := false
+		for i, existing := range resolvedEgressRules {
+			if existing.Name == rule.Name {
+				resolvedEgressRules[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			resolvedEgressRules = append(resolvedEgressRules, rule)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, rule := range resolvedEgressRules {
+		if !dns1123LabelPattern.MatchString(rule.Name) {
+			return fmt.Errorf("egress rule %q: name must be a lowercase DNS label", rule.Name)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate egress rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.CIDRs) == 0 {
+			return fmt.Errorf("egress rule %s: at least one CIDR is required", rule.Name)
+		}
+		for _, cidr := range rule.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("egress rule %s: invalid CIDR %q", rule.Name, cidr)
+			}
+		}
+		if _, err := parsePolicyPorts(rule.Ports); err != nil {
+			return fmt.Errorf("egress rule %s: %w", rule.Name, err)
+		}
+	}
+
+	if !networkPolicyEnabled && len(resolvedEgressRules) > 0 {
+		warnf("egress rules are ignored without --network-policy-enabled")
+	}
+	return nil
+}
+
+// Parse NAME,cidr=CIDR[,cidr=CIDR...][,port=PORT[-END_PORT][/PROTOCOL]...]
+func parseEgressRule(spec string) (EgressRuleConfig, error) {
+	parts := strings.Split(spec, ",")
+	rule := EgressRuleConfig{Name: parts[0]}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return rule, fmt.Errorf("expected key=value, got %q", part)
+		}
+		switch kv[0] {
+		case "cidr":
+			rule.CIDRs = append(rule.CIDRs, kv[1])
+		case "port":
+			rule.Ports = append(rule.Ports, kv[1])
+		default:
+			return rule, fmt.Errorf("unknown key %q (expected cidr or port)", kv[0])
+		}
+	}
+	return rule, nil
+}
+
+// Parse PORT[-END_PORT][/PROTOCOL] entries; ports default to TCP
+func parsePolicyPorts(specs []string) ([]NetworkPolicyPort, error) {
+	var ports []NetworkPolicyPort
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "/", 2)
+		protocol := "TCP"
+		if len(parts) == 2 {
+			protocol = strings.ToUpper(parts[1])
+		}
+		if protocol != "TCP" && protocol != "UDP" && protocol != "SCTP" {
+			return nil, fmt.Errorf("invalid port %q: protocol must be TCP, UDP or SCTP", spec)
+		}
+
+		bounds := strings.SplitN(parts[0], "-", 2)
+		port, err := strconv.Atoi(bounds[0])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q: expected a number between 1 and 65535", spec)
+		}
+		policyPort := NetworkPolicyPort{Protocol: protocol, Port: port}
+		if len(bounds) == 2 {
+			endPort, err := strconv.Atoi(bounds[1])
+			if err != nil || endPort < port || endPort > 65535 {
+				return nil, fmt.Errorf("invalid port range %q", spec)
+			}
+			policyPort.EndPort = &endPort
+		}
+		ports = append(ports, policyPort)
+	}
+	return ports, nil
+}
+
+// Namespace and pod labels of the controller serving the ingress class
+func ingressControllerPeer() (NetworkPolicyPeer, error) {
+	controller := ingressControllers[ingressClass]
+	if networkPolicyIngressNamespace != "" {
+		controller.Namespace = networkPolicyIngressNamespace
+	}
+	if len(networkPolicyIngressLabels) > 0 {
+		controller.PodLabels = networkPolicyIngressLabels
+	}
+	if controller.Namespace == "" {
+		return NetworkPolicyPeer{}, fmt.Errorf("no known ingress controller namespace for ingress class %q; set --network-policy-ingress-namespace", ingressClass)
+	}
+
+	peer := NetworkPolicyPeer{
+		NamespaceSelector: &PeerSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": controller.Namespace}},
+	}
+	if len(controller.PodLabels) > 0 {
+		peer.PodSelector = &PeerSelector{MatchLabels: controller.PodLabels}
+	}
+	return peer, nil
+}
+
+// Labels shared by every pod of the app, whatever its component
+func appSelectorLabels() map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":     "k8s-config-generator",
+		"app.kubernetes.io/instance": appName,
+	}
+}
+
+// Create NetworkPolicy resources: a default deny for the namespace and the
+// allow rules for the traffic the app needs. The ingress controller may only
+// reach the component behind the Ingress, on the port its Service targets.
+func createNetworkPolicies(ns string, withIngress bool) ([]*NetworkPolicy, error) {
+	newPolicy := func(name string, podLabels map[string]string, policyTypes ...string) *NetworkPolicy {
+		return &NetworkPolicy{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+			Metadata: Metadata{
+				Name:      fmt.Sprintf("%s-%s", appName, name),
+				Namespace: ns,
+			},
+			Spec: NetworkPolicySpec{
+				PodSelector: PeerSelector{MatchLabels: podLabels},
+				PolicyTypes: policyTypes,
+			},
+		}
+	}
+
+	var policies []*NetworkPolicy
+	if networkPolicyDefaultDeny {
+		policies = append(policies, newPolicy("default-deny", nil, "Ingress", "Egress"))
+	}
+
+	if networkPolicyAllowSameNamespace {
+		policy := newPolicy("allow-same-namespace", appSelectorLabels(), "Ingress", "Egress")
+		sameNamespace := []NetworkPolicyPeer{{PodSelector: &PeerSelector{}}}
+		policy.Spec.Ingress = []NetworkPolicyRule{{From: sameNamespace}}
+		policy.Spec.Egress = []NetworkPolicyRule{{To: sameNamespace}}
+		policies = append(policies, policy)
+	}
+
+	if withIngress {
+		peer, err := ingressControllerPeer()
+		if err != nil {
+			return nil, err
+		}
+		exposed := exposedComponents()
+		policy := newPolicy("allow-ingress-controller", exposed[0].selectorLabels(), "Ingress")
+		policy.Spec.Ingress = []NetworkPolicyRule{{
+			From:  []NetworkPolicyPeer{peer},
+			Ports: []NetworkPolicyPort{{Protocol: "TCP", Port: "http-port"}},
+		}}
+		policies = append(policies, policy)
+	}
+
+	if networkPolicyAllowDNS {
+		policy := newPolicy("allow-dns", appSelectorLabels(), "Egress")
+		policy.Spec.Egress = []NetworkPolicyRule{{
+			To: []NetworkPolicyPeer{{
+				NamespaceSelector: &PeerSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"}},
+				PodSelector:       &PeerSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+			}},
+			Ports: []NetworkPolicyPort{{Protocol: "UDP", Port: 53}, {Protocol: "TCP", Port: 53}},
+		}}
+		policies = append(policies, policy)
+	}
+
+	for _, rule := range resolvedEgressRules {
+		var to []NetworkPolicyPeer
+		for _, cidr := range rule.CIDRs {
+			to = append(to, NetworkPolicyPeer{IPBlock: &IPBlock{CIDR: cidr}})
+		}
+		ports, _ := parsePolicyPorts(rule.Ports)
+		policy := newPolicy("allow-egress-"+rule.Name, appSelectorLabels(), "Egress")
+		policy.Spec.Egress = []NetworkPolicyRule{{To: to, Ports: ports}}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
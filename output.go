@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	manifestEncoding string
+	singleFile       bool
+)
+
+var manifestEncodings = []string{"yaml", "json", "ndjson"}
+
+// Check the output format against the layout and output mode
+func resolveOutputEncoding() error {
+	if !containsString(manifestEncodings, manifestEncoding) {
+		return fmt.Errorf("invalid --output-format %q (expected yaml, json or ndjson)", manifestEncoding)
+	}
+	if outputFormat != "flat" && (manifestEncoding != "yaml" || singleFile) {
+		return fmt.Errorf("--output-format and --single-file only apply to --format flat")
+	}
+	if diffMode && singleFile {
+		return fmt.Errorf("--diff compares a file per resource and doesn't support --single-file")
+	}
+	// One object per line only makes sense in a single stream
+	if manifestEncoding == "ndjson" && !singleFile && !(render && outputDir == "") {
+		return fmt.Errorf("--output-format ndjson writes one stream; use --single-file or --render without --output-dir")
+	}
+	return nil
+}
+
+// File extension of a manifest file
+func manifestExtension() string {
+	if manifestEncoding == "yaml" {
+		return ".yaml"
+	}
+	return ".json"
+}
+
+// Name of the file --single-file writes
+func singleFileName() string {
+	switch manifestEncoding {
+	case "json":
+		return "all.json"
+	case "ndjson":
+		return "all.ndjson"
+	}
+	return "all.yaml"
+}
+
+// Write the manifests of one directory, as a file per resource or with
+// --single-file as one file; returns the names of the files written
+func writeManifests(manifests []interface{}, dir string) ([]string, error) {
+	if !singleFile {
+		var files []string
+		for _, manifest := range manifests {
+			filename, err := writeManifestToFile(manifest, dir)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, filename)
+		}
+		return files, nil
+	}
+
+	data, err := encodeManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+	filename := singleFileName()
+	filePath := filepath.Join(dir, filename)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	return []string{filename}, nil
+}
+
+// Encode a single manifest in the output format
+func encodeManifest(manifest interface{}) ([]byte, error) {
+	if manifestEncoding == "yaml" {
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+		return data, nil
+	}
+
+	data, err := manifestJSON(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if manifestEncoding == "json" {
+		return indentJSON(data)
+	}
+	return append(data, '\n'), nil
+}
+
+// Encode manifests as one stream: YAML documents, a JSON List or one JSON
+// object per line
+func encodeManifests(manifests []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch manifestEncoding {
+	case "json":
+		buf.WriteString(`{"apiVersion":"v1","kind":"List","items":[`)
+		for i, manifest := range manifests {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			data, err := manifestJSON(manifest)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		}
+		buf.WriteString("]}")
+		return indentJSON(buf.Bytes())
+	default:
+		for _, manifest := range manifests {
+			if manifestEncoding == "yaml" {
+				buf.WriteString("---\n")
+			}
+			data, err := encodeManifest(manifest)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+func indentJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return nil, fmt.Errorf("failed to format JSON: %w", err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Compact JSON of a manifest, with the fields in the order they are
+// generated in rather than sorted
+func manifestJSON(manifest interface{}) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := writeJSONNode(&buf, &node); err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return writeJSONNode(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSONNode(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, node.Content[i].Value); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSONNode(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONNode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		// Kubernetes reads timestamps and binary data as strings
+		var value interface{} = node.Value
+		switch node.ShortTag() {
+		case "!!str", "!!timestamp", "!!binary":
+		default:
+			if err := node.Decode(&value); err != nil {
+				return err
+			}
+		}
+		return writeJSONValue(buf, value)
+	}
+	return nil
+}
+
+// JSON of a scalar, without escaping <, > and & as for HTML
+func writeJSONValue(buf *bytes.Buffer, value interface{}) error {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(out.Bytes(), []byte("\n")))
+	return nil
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PatchConfig applies a patch file to the generated objects of a kind,
+// optionally only to the one with a name and only in some environments
+type PatchConfig struct {
+	Path string `yaml:"path"`
+	// json (RFC 6902), merge (RFC 7386) or strategic; JSON patches are
+	// detected by their list of operations and the rest default to strategic
+	Type   string      `yaml:"type"`
+	Target PatchTarget `yaml:"target"`
+}
+
+type PatchTarget struct {
+	Kind         string   `yaml:"kind"`
+	Name         string   `yaml:"name"`
+	Environments []string `yaml:"environments"`
+}
+
+// A patch with its file loaded
+type loadedPatch struct {
+	PatchConfig
+	document   *yaml.Node
+	operations []jsonPatchOperation
+}
+
+type jsonPatchOperation struct {
+	Op    string    `yaml:"op"`
+	Path  string    `yaml:"path"`
+	From  string    `yaml:"from"`
+	Value yaml.Node `yaml:"value"`
+}
+
+// PatchedObject is a generated object after a patch changed it. It is kept as
+// a YAML node so the fields keep the order they were generated in.
+type PatchedObject struct {
+	node *yaml.Node
+}
+
+var (
+	patchFlags      []string
+	patchConfigs    []PatchConfig
+	resolvedPatches []loadedPatch
+)
+
+var (
+	patchTypes   = []string{"json", "merge", "strategic"}
+	jsonPatchOps = []string{"add", "remove", "replace", "move", "copy", "test"}
+)
+
+// Load the patches from the config file and the --patch flags, in the order
+// they are applied. Runs after the environments are resolved.
+func resolvePatches() error {
+	configs := append([]PatchConfig(nil), patchConfigs...)
+	for _, spec := range patchFlags {
+		p, err := parsePatchSpec(spec)
+		if err != nil {
+			return fmt.Errorf("invalid --patch %q: %w", spec, err)
+		}
+		configs = append(configs, p)
+	}
+
+	resolvedPatches = nil
+	for _, p := range configs {
+		if p.Path == "" {
+			return fmt.Errorf("patch without a path")
+		}
+		loaded, err := loadPatch(p)
+		if err != nil {
+			return fmt.Errorf("patch %s: %w", p.Path, err)
+		}
+		resolvedPatches = append(resolvedPatches, loaded)
+	}
+	return nil
+}
+
+// Parse FILE[,type=TYPE][,kind=KIND][,name=NAME][,env=ENV...]
+func parsePatchSpec(spec string) (PatchConfig, error) {
+	parts := strings.Split(spec, ",")
+	p := PatchConfig{Path: parts[0]}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return p, fmt.Errorf("expected key=value, got %q", part)
+		}
+		switch kv[0] {
+		case "type":
+			p.Type = kv[1]
+		case "kind":
+			p.Target.Kind = kv[1]
+		case "name":
+			p.Target.Name = kv[1]
+		case "env":
+			p.Target.Environments = append(p.Target.Environments, kv[1])
+		default:
+			return p, fmt.Errorf("unknown key %q (expected type, kind, name or env)", kv[0])
+		}
+	}
+	return p, nil
+}
+
+// Read and check a patch file; merge patches name their target with kind and
+// metadata.name unless the config says otherwise
+func loadPatch(p PatchConfig) (loadedPatch, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return loadedPatch{}, fmt.Errorf("failed to read patch file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return loadedPatch{}, fmt.Errorf("failed to parse patch file: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return loadedPatch{}, fmt.Errorf("patch file is empty")
+	}
+	root := doc.Content[0]
+
+	loaded := loadedPatch{PatchConfig: p}
+	if loaded.Type == "" {
+		loaded.Type = "strategic"
+		if root.Kind == yaml.SequenceNode {
+			loaded.Type = "json"
+		}
+	}
+	if !containsString(patchTypes, loaded.Type) {
+		return loaded, fmt.Errorf("invalid type %q (expected json, merge or strategic)", loaded.Type)
+	}
+
+	if loaded.Type == "json" {
+		if root.Kind != yaml.SequenceNode {
+			return loaded, fmt.Errorf("a JSON patch must be a list of operations")
+		}
+		if err := root.Decode(&loaded.operations); err != nil {
+			return loaded, fmt.Errorf("failed to parse JSON patch: %w", err)
+		}
+		for i, op := range loaded.operations {
+			if err := op.check(); err != nil {
+				return loaded, fmt.Errorf("operation %d: %w", i+1, err)
+			}
+		}
+	} else {
+		if root.Kind != yaml.MappingNode {
+			return loaded, fmt.Errorf("a %s merge patch must be an object", loaded.Type)
+		}
+		loaded.document = root
+		if loaded.Type == "strategic" {
+			if err := checkStrategicDirectives(root, "", ""); err != nil {
+				return loaded, err
+			}
+		}
+		if loaded.Target.Kind == "" {
+			if kind := mappingValue(root, "kind"); kind != nil {
+				loaded.Target.Kind = kind.Value
+			}
+		}
+		if loaded.Target.Name == "" {
+			if name := mappingValue(mappingValue(root, "metadata"), "name"); name != nil {
+				loaded.Target.Name = name.Value
+			}
+		}
+	}
+
+	if loaded.Target.Kind == "" {
+		return loaded, fmt.Errorf("no target kind (set target.kind, or kind in a merge patch)")
+	}
+	for _, envName := range loaded.Target.Environments {
+		if !knownEnvironment(envName) {
+			return loaded, fmt.Errorf("unknown environment %q (defined: %s)", envName, strings.Join(environmentNames, ", "))
+		}
+	}
+	return loaded, nil
+}
+
+func (op jsonPatchOperation) check() error {
+	if !containsString(jsonPatchOps, op.Op) {
+		return fmt.Errorf("invalid op %q (expected %s)", op.Op, strings.Join(jsonPatchOps, ", "))
+	}
+	if _, err := parsePointer(op.Path); err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value.Kind == 0 {
+			return fmt.Errorf("%s %s needs a value", op.Op, op.Path)
+		}
+	case "move", "copy":
+		if _, err := parsePointer(op.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+	}
+	return nil
+}
+
+// Apply the patches for an environment to its generated objects. A patch
+// that matches nothing is an error, so renaming a resource can't silently
+// leave its patch behind.
+func applyPatches(manifests []interface{}, envName string) ([]interface{}, error) {
+	for _, p := range resolvedPatches {
+		if len(p.Target.Environments) > 0 && !containsString(p.Target.Environments, envName) {
+			continue
+		}
+
+		matched := false
+		var generated []string
+		for i, manifest := range manifests {
+			kind, name, err := manifestIdentity(manifest)
+			if err != nil {
+				return nil, err
+			}
+			if kind != p.Target.Kind {
+				continue
+			}
+			generated = append(generated, name)
+			if p.Target.Name != "" && name != p.Target.Name {
+				continue
+			}
+			matched = true
+
+			if _, ok := manifest.(*SopsSecret); ok {
+				return nil, fmt.Errorf("patch %s: Secret %s is encrypted with sops and can't be patched", p.Path, name)
+			}
+			patched, err := toPatchedObject(manifest)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.apply(patched.node); err != nil {
+				return nil, fmt.Errorf("patch %s on %s %s: %w", p.Path, kind, name, err)
+			}
+			manifests[i] = patched
+		}
+
+		if !matched {
+			target := p.Target.Kind
+			if p.Target.Name != "" {
+				target = fmt.Sprintf("%s %s", p.Target.Kind, p.Target.Name)
+			}
+			generatedList := "none"
+			if len(generated) > 0 {
+				generatedList = strings.Join(generated, ", ")
+			}
+			return nil, fmt.Errorf("patch %s: target %s does not exist in %s (generated %s objects: %s)", p.Path, target, environmentLabel(envName), p.Target.Kind, generatedList)
+		}
+	}
+	return manifests, nil
+}
+
+func (p loadedPatch) apply(node *yaml.Node) error {
+	switch p.Type {
+	case "json":
+		for i, op := range p.operations {
+			if err := applyJSONPatchOperation(node, op); err != nil {
+				return fmt.Errorf("operation %d (%s %s): %w", i+1, op.Op, op.Path, err)
+			}
+		}
+		return nil
+	default:
+		*node = *mergePatch(node, p.document, p.Type == "strategic", "")
+		return nil
+	}
+}
+
+// Full Kubernetes kind and name of a manifest
+func manifestIdentity(manifest interface{}) (string, string, error) {
+	switch m := manifest.(type) {
+	case *PatchedObject:
+		return m.kind(), m.name(), nil
+	case *SopsSecret:
+		return "Secret", m.Secret.Metadata.Name, nil
+	}
+	object, err := toObject(manifest)
+	if err != nil {
+		return "", "", err
+	}
+	kind, _ := object["kind"].(string)
+	_, name := manifestKindName(manifest)
+	return kind, name, nil
+}
+
+func toPatchedObject(manifest interface{}) (*PatchedObject, error) {
+	if patched, ok := manifest.(*PatchedObject); ok {
+		return patched, nil
+	}
+	var node yaml.Node
+	if err := node.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	return &PatchedObject{node: &node}, nil
+}
+
+func (p *PatchedObject) MarshalYAML() (interface{}, error) {
+	return p.node, nil
+}
+
+func (p *PatchedObject) kind() string {
+	if kind := mappingValue(p.node, "kind"); kind != nil {
+		return kind.Value
+	}
+	return ""
+}
+
+func (p *PatchedObject) name() string {
+	if name := mappingValue(mappingValue(p.node, "metadata"), "name"); name != nil {
+		return name.Value
+	}
+	return ""
+}
+
+// Pod template of a patched workload, or nil
+func (p *PatchedObject) podTemplate() *yaml.Node {
+	spec := mappingValue(p.node, "spec")
+	if p.kind() == "CronJob" {
+		spec = mappingValue(mappingValue(spec, "jobTemplate"), "spec")
+	}
+	return mappingValue(spec, "template")
+}
+
+// Containers of a patched workload, in its pod template
+func (p *PatchedObject) containers() []*yaml.Node {
+	containers := mappingValue(mappingValue(p.podTemplate(), "spec"), "containers")
+	if containers == nil {
+		return nil
+	}
+	return containers.Content
+}
+
+// Value of a key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingValue(node *yaml.Node, key string) bool {
+	if node == nil {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// Copy of a patch value, written in the block style of the generated files
+// whatever style the patch file uses
+func cloneNode(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		return cloneNode(node.Alias)
+	}
+	clone := &yaml.Node{
+		Kind:  node.Kind,
+		Style: node.Style &^ (yaml.FlowStyle | yaml.TaggedStyle),
+		Tag:   node.Tag,
+		Value: node.Value,
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && !strings.Contains(node.Value, "\n") {
+		clone.Style &^= yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle
+	}
+	for _, child := range node.Content {
+		clone.Content = append(clone.Content, cloneNode(child))
+	}
+	return clone
+}
+
+// Merge a patch into a node: objects merge key by key and null deletes a key
+// (RFC 7386). Strategic patches also merge lists of objects by their merge
+// key and understand $patch: replace and $patch: delete.
+func mergePatch(target, patch *yaml.Node, strategic bool, field string) *yaml.Node {
+	switch patch.Kind {
+	case yaml.MappingNode:
+		if strategic && patchDirective(patch) == "replace" {
+			return withoutDirective(patch)
+		}
+		if target == nil || target.Kind != yaml.MappingNode {
+			target = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		for i := 0; i+1 < len(patch.Content); i += 2 {
+			key, value := patch.Content[i].Value, patch.Content[i+1]
+			if strategic && key == "$patch" {
+				continue
+			}
+			if isNull(value) || (strategic && value.Kind == yaml.MappingNode && patchDirective(value) == "delete") {
+				deleteMappingValue(target, key)
+				continue
+			}
+			setMappingValue(target, key, mergePatch(mappingValue(target, key), value, strategic, key))
+		}
+		return target
+	case yaml.SequenceNode:
+		if strategic && target != nil && target.Kind == yaml.SequenceNode {
+			if key := listMergeKey(field, patch); key != "" {
+				return mergeList(target, patch, key)
+			}
+		}
+	}
+	return cloneNode(patch)
+}
+
+// Merge key of a list in a strategic patch, if every item in the patch has it
+func listMergeKey(field string, patch *yaml.Node) string {
+	candidates := []string{patchMergeKeys[field]}
+	if field == "ports" {
+		candidates = append(candidates, "port")
+	}
+	for _, key := range candidates {
+		if key == "" {
+			continue
+		}
+		found := len(patch.Content) > 0
+		for _, item := range patch.Content {
+			if mappingValue(item, key) == nil {
+				found = false
+			}
+		}
+		if found {
+			return key
+		}
+	}
+	return ""
+}
+
+func mergeList(target, patch *yaml.Node, key string) *yaml.Node {
+	for _, item := range patch.Content {
+		value := mappingValue(item, key).Value
+		index := -1
+		for i, existing := range target.Content {
+			if v := mappingValue(existing, key); v != nil && v.Value == value {
+				index = i
+			}
+		}
+
+		switch {
+		case patchDirective(item) == "delete":
+			if index >= 0 {
+				target.Content = append(target.Content[:index], target.Content[index+1:]...)
+			}
+		case index >= 0:
+			target.Content[index] = mergePatch(target.Content[index], item, true, "")
+		default:
+			target.Content = append(target.Content, withoutDirective(item))
+		}
+	}
+	return target
+}
+
+// Reject the strategic merge directives mergePatch doesn't implement, rather
+// than copying them into the object: $setElementOrder, $retainKeys,
+// $deleteFromPrimitiveList and $patch in a list that isn't merged by key,
+// such as the list-wide $patch: replace item
+func checkStrategicDirectives(node *yaml.Node, field, path string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			switch {
+			case key == "$patch":
+				if value.Value != "replace" && value.Value != "delete" && value.Value != "merge" {
+					return fmt.Errorf("%s: unknown directive $patch: %s (expected replace, delete or merge)", displayPath(path), value.Value)
+				}
+			case strings.HasPrefix(key, "$"):
+				return fmt.Errorf("%s: the %s directive is not supported; use a JSON patch instead", displayPath(path), key)
+			}
+			if err := checkStrategicDirectives(value, key, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		merged := listMergeKey(field, node) != ""
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if directive := patchDirective(item); directive != "" && !merged && patchMergeKeys[field] != "" {
+				return fmt.Errorf("%s: $patch: %s needs %s on every item of %s; a list-wide $patch is not supported, use a JSON patch instead", displayPath(itemPath), directive, patchMergeKeys[field], field)
+			}
+			if directive := patchDirective(item); directive != "" && !merged {
+				return fmt.Errorf("%s: $patch: %s is only supported on items of a list merged by key (%s); use a JSON patch instead", displayPath(itemPath), directive, mergedListFields())
+			}
+			if err := checkStrategicDirectives(item, "", itemPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lists a strategic patch merges by key, for error messages
+func mergedListFields() string {
+	fields := make([]string, 0, len(patchMergeKeys))
+	for field := range patchMergeKeys {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
+
+// Field path of a patch for error messages
+func displayPath(path string) string {
+	if path == "" {
+		return "patch"
+	}
+	return strings.TrimPrefix(path, ".")
+}
+
+func patchDirective(node *yaml.Node) string {
+	if directive := mappingValue(node, "$patch"); directive != nil {
+		return directive.Value
+	}
+	return ""
+}
+
+func withoutDirective(node *yaml.Node) *yaml.Node {
+	clone := cloneNode(node)
+	deleteMappingValue(clone, "$patch")
+	return clone
+}
+
+// Split a JSON pointer (RFC 6901) into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON pointer %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// Node a JSON pointer refers to
+func resolvePointer(root *yaml.Node, tokens []string) (*yaml.Node, error) {
+	node := root
+	for i, token := range tokens {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			next = mappingValue(node, token)
+		case yaml.SequenceNode:
+			if index, err := arrayIndex(token, len(node.Content)-1); err == nil {
+				next = node.Content[index]
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("path /%s does not exist", strings.Join(tokens[:i+1], "/"))
+		}
+		node = next
+	}
+	return node, nil
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d is out of range", index)
+	}
+	return index, nil
+}
+
+func applyJSONPatchOperation(root *yaml.Node, op jsonPatchOperation) error {
+	path, _ := parsePointer(op.Path)
+	switch op.Op {
+	case "add":
+		return addAtPointer(root, path, cloneNode(&op.Value))
+	case "remove":
+		_, err := removeAtPointer(root, path)
+		return err
+	case "replace":
+		value, err := resolvePointer(root, path)
+		if err != nil {
+			return err
+		}
+		*value = *cloneNode(&op.Value)
+	case "move":
+		from, _ := parsePointer(op.From)
+		if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+			return fmt.Errorf("can't move %s into itself", op.From)
+		}
+		value, err := removeAtPointer(root, from)
+		if err != nil {
+			return err
+		}
+		return addAtPointer(root, path, value)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		value, err := resolvePointer(root, from)
+		if err != nil {
+			return err
+		}
+		return addAtPointer(root, path, cloneNode(value))
+	case "test":
+		value, err := resolvePointer(root, path)
+		if err != nil {
+			return err
+		}
+		var actual, expected interface{}
+		if err := value.Decode(&actual); err != nil {
+			return err
+		}
+		if err := op.Value.Decode(&expected); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			return fmt.Errorf("test failed: value is %v", actual)
+		}
+	}
+	return nil
+}
+
+func addAtPointer(root *yaml.Node, path []string, value *yaml.Node) error {
+	if len(path) == 0 {
+		*root = *value
+		return nil
+	}
+	parent, err := resolvePointer(root, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	switch parent.Kind {
+	case yaml.MappingNode:
+		setMappingValue(parent, last, value)
+	case yaml.SequenceNode:
+		if last == "-" {
+			parent.Content = append(parent.Content, value)
+			return nil
+		}
+		index, err := arrayIndex(last, len(parent.Content))
+		if err != nil {
+			return err
+		}
+		parent.Content = append(parent.Content[:index], append([]*yaml.Node{value}, parent.Content[index:]...)...)
+	default:
+		return fmt.Errorf("parent of %s is not an object or a list", last)
+	}
+	return nil
+}
+
+func removeAtPointer(root *yaml.Node, path []string) (*yaml.Node, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("can't remove the whole object")
+	}
+	value, err := resolvePointer(root, path)
+	if err != nil {
+		return nil, err
+	}
+	parent, _ := resolvePointer(root, path[:len(path)-1])
+	last := path[len(path)-1]
+	if parent.Kind == yaml.MappingNode {
+		deleteMappingValue(parent, last)
+	} else {
+		index, _ := arrayIndex(last, len(parent.Content)-1)
+		parent.Content = append(parent.Content[:index], parent.Content[index+1:]...)
+	}
+	return value, nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseNode(t *testing.T, source string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(source), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Content[0]
+}
+
+func assertNodeEqual(t *testing.T, got *yaml.Node, want string) {
+	t.Helper()
+	var actual, expected interface{}
+	if err := got.Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(want), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		out, _ := yaml.Marshal(got)
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestApplyJSONPatchOperation(t *testing.T) {
+	const document = `
+metadata:
+  name: web
+  annotations:
+    example.com/a: x
+    m~n: y
+spec:
+  list: [a, b, c]
+`
+	tests := []struct {
+		name    string
+		ops     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "add a key",
+			ops:  `[{op: add, path: /spec/replicas, value: 2}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [a, b, c], replicas: 2}}`,
+		},
+		{
+			name: "add inserts at an index",
+			ops:  `[{op: add, path: /spec/list/1, value: z}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [a, z, b, c]}}`,
+		},
+		{
+			name: "add appends with -",
+			ops:  `[{op: add, path: /spec/list/-, value: z}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [a, b, c, z]}}`,
+		},
+		{
+			name: "add appends at the length",
+			ops:  `[{op: add, path: /spec/list/3, value: z}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [a, b, c, z]}}`,
+		},
+		{
+			name:    "add past the end",
+			ops:     `[{op: add, path: /spec/list/4, value: z}]`,
+			wantErr: "array index 4 is out of range",
+		},
+		{
+			name:    "add with a leading zero",
+			ops:     `[{op: add, path: /spec/list/01, value: z}]`,
+			wantErr: `invalid array index "01"`,
+		},
+		{
+			name:    "add under a missing parent",
+			ops:     `[{op: add, path: /spec/template/spec, value: {}}]`,
+			wantErr: "path /spec/template does not exist",
+		},
+		{
+			name: "remove a list item",
+			ops:  `[{op: remove, path: /spec/list/0}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [b, c]}}`,
+		},
+		{
+			name:    "remove out of range",
+			ops:     `[{op: remove, path: /spec/list/3}]`,
+			wantErr: "path /spec/list/3 does not exist",
+		},
+		{
+			name:    "remove with -",
+			ops:     `[{op: remove, path: /spec/list/-}]`,
+			wantErr: "path /spec/list/- does not exist",
+		},
+		{
+			name: "replace",
+			ops:  `[{op: replace, path: /metadata/name, value: api}]`,
+			want: `{metadata: {name: api, annotations: {example.com/a: x, m~n: y}}, spec: {list: [a, b, c]}}`,
+		},
+		{
+			name:    "replace a missing key",
+			ops:     `[{op: replace, path: /metadata/namespace, value: prod}]`,
+			wantErr: "path /metadata/namespace does not exist",
+		},
+		{
+			name: "move",
+			ops:  `[{op: move, from: /spec/list/0, path: /spec/first}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [b, c], first: a}}`,
+		},
+		{
+			name: "move to a sibling with the same prefix",
+			ops:  `[{op: move, from: /spec/list, path: /spec/list2}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list2: [a, b, c]}}`,
+		},
+		{
+			name:    "move into itself",
+			ops:     `[{op: move, from: /spec, path: /spec/inner}]`,
+			wantErr: "can't move /spec into itself",
+		},
+		{
+			name: "copy",
+			ops:  `[{op: copy, from: /spec/list, path: /spec/copy}, {op: add, path: /spec/copy/-, value: d}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: x, m~n: y}}, spec: {list: [a, b, c], copy: [a, b, c, d]}}`,
+		},
+		{
+			name: "test passes",
+			ops:  `[{op: test, path: /spec/list, value: [a, b, c]}, {op: test, path: /spec/list/1, value: b}]`,
+			want: document,
+		},
+		{
+			name:    "test fails",
+			ops:     `[{op: test, path: /spec/list/1, value: c}]`,
+			wantErr: "test failed: value is b",
+		},
+		{
+			name: "~1 and ~0 escapes",
+			ops:  `[{op: replace, path: /metadata/annotations/example.com~1a, value: z}, {op: remove, path: /metadata/annotations/m~0n}]`,
+			want: `{metadata: {name: web, annotations: {example.com/a: z}}, spec: {list: [a, b, c]}}`,
+		},
+		{
+			name:    "pointer without a leading slash",
+			ops:     `[{op: remove, path: spec}]`,
+			wantErr: "must start with /",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := parseNode(t, document)
+			var ops []jsonPatchOperation
+			if err := parseNode(t, tt.ops).Decode(&ops); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			for _, op := range ops {
+				if err = op.check(); err != nil {
+					break
+				}
+				if err = applyJSONPatchOperation(root, op); err != nil {
+					break
+				}
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertNodeEqual(t, root, tt.want)
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	const deployment = `
+kind: Deployment
+metadata:
+  name: web
+  labels: {app: web, tier: api}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1
+        args: [--port, "8080"]
+        ports:
+        - {name: http, containerPort: 8080}
+        env:
+        - {name: A, value: "1"}
+        - {name: B, value: "2"}
+      - name: sidecar
+        image: proxy:1
+`
+	const service = `
+kind: Service
+spec:
+  ports:
+  - {name: http, port: 80, targetPort: http}
+`
+	tests := []struct {
+		name      string
+		target    string
+		patch     string
+		strategic bool
+		want      string
+	}{
+		{
+			name:      "merge patch replaces lists and deletes nulls",
+			target:    deployment,
+			patch:     `{metadata: {labels: {tier: null, team: core}}, spec: {template: {spec: {containers: [{name: web, image: web:2}]}}}}`,
+			strategic: false,
+			want: `
+kind: Deployment
+metadata: {name: web, labels: {app: web, team: core}}
+spec: {template: {spec: {containers: [{name: web, image: web:2}]}}}
+`,
+		},
+		{
+			name:      "container merged by name",
+			target:    deployment,
+			patch:     `{spec: {template: {spec: {containers: [{name: web, image: web:2, env: [{name: B, value: "3"}, {name: C, value: "4"}]}]}}}}`,
+			strategic: true,
+			want: `
+kind: Deployment
+metadata: {name: web, labels: {app: web, tier: api}}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:2
+        args: [--port, "8080"]
+        ports: [{name: http, containerPort: 8080}]
+        env: [{name: A, value: "1"}, {name: B, value: "3"}, {name: C, value: "4"}]
+      - {name: sidecar, image: proxy:1}
+`,
+		},
+		{
+			name:      "$patch: delete on a container",
+			target:    deployment,
+			patch:     `{spec: {template: {spec: {containers: [{name: sidecar, $patch: delete}]}}}}`,
+			strategic: true,
+			want: `
+kind: Deployment
+metadata: {name: web, labels: {app: web, tier: api}}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1
+        args: [--port, "8080"]
+        ports: [{name: http, containerPort: 8080}]
+        env: [{name: A, value: "1"}, {name: B, value: "2"}]
+`,
+		},
+		{
+			name:      "$patch: replace on a container",
+			target:    deployment,
+			patch:     `{spec: {template: {spec: {containers: [{name: web, image: web:2, $patch: replace}]}}}}`,
+			strategic: true,
+			want: `
+kind: Deployment
+metadata: {name: web, labels: {app: web, tier: api}}
+spec: {template: {spec: {containers: [{name: web, image: web:2}, {name: sidecar, image: proxy:1}]}}}
+`,
+		},
+		{
+			name:      "$patch: replace on an object",
+			target:    deployment,
+			patch:     `{metadata: {labels: {app: api, $patch: replace}}}`,
+			strategic: true,
+			want: `
+kind: Deployment
+metadata: {name: web, labels: {app: api}}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1
+        args: [--port, "8080"]
+        ports: [{name: http, containerPort: 8080}]
+        env: [{name: A, value: "1"}, {name: B, value: "2"}]
+      - {name: sidecar, image: proxy:1}
+`,
+		},
+		{
+			name:      "container ports merged by containerPort",
+			target:    deployment,
+			patch:     `{spec: {template: {spec: {containers: [{name: web, args: [--verbose], ports: [{containerPort: 8080, protocol: TCP}, {name: metrics, containerPort: 9090}]}]}}}}`,
+			strategic: true,
+			want: `
+kind: Deployment
+metadata: {name: web, labels: {app: web, tier: api}}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1
+        args: [--verbose]
+        ports: [{name: http, containerPort: 8080, protocol: TCP}, {name: metrics, containerPort: 9090}]
+        env: [{name: A, value: "1"}, {name: B, value: "2"}]
+      - {name: sidecar, image: proxy:1}
+`,
+		},
+		{
+			name:      "service ports merged by port",
+			target:    service,
+			patch:     `{spec: {ports: [{port: 80, targetPort: 8080}, {name: https, port: 443, targetPort: https}]}}`,
+			strategic: true,
+			want: `
+kind: Service
+spec:
+  ports:
+  - {name: http, port: 80, targetPort: 8080}
+  - {name: https, port: 443, targetPort: https}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch := parseNode(t, tt.patch)
+			if tt.strategic {
+				if err := checkStrategicDirectives(patch, "", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := mergePatch(parseNode(t, tt.target), patch, tt.strategic, "")
+			assertNodeEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestCheckStrategicDirectives(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr string
+	}{
+		{
+			name:    "$setElementOrder",
+			patch:   `{spec: {$setElementOrder/containers: [{name: web}], containers: [{name: web, image: web:2}]}}`,
+			wantErr: "spec: the $setElementOrder/containers directive is not supported",
+		},
+		{
+			name:    "$retainKeys",
+			patch:   `{spec: {strategy: {$retainKeys: [type], type: Recreate}}}`,
+			wantErr: "spec.strategy: the $retainKeys directive is not supported",
+		},
+		{
+			name:    "list-wide $patch: replace",
+			patch:   `{spec: {containers: [{$patch: replace}, {name: web, image: web:2}]}}`,
+			wantErr: "spec.containers[0]: $patch: replace needs name on every item of containers",
+		},
+		{
+			name:    "$patch in a list without a merge key",
+			patch:   `{spec: {containers: [{name: web, args: [{$patch: delete}]}]}}`,
+			wantErr: "spec.containers[0].args[0]: $patch: delete is only supported",
+		},
+		{
+			name:    "unknown $patch",
+			patch:   `{metadata: {labels: {$patch: remove}}}`,
+			wantErr: "metadata.labels: unknown directive $patch: remove",
+		},
+		{
+			name:  "supported directives",
+			patch: `{metadata: {labels: {app: web, $patch: replace}}, spec: {containers: [{name: sidecar, $patch: delete}, {name: web, $patch: merge, env: [{name: A, $patch: delete}]}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStrategicDirectives(parseNode(t, tt.patch), "", "")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type PodDisruptionBudget struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   Metadata `yaml:"metadata"`
+	Spec       PDBSpec  `yaml:"spec"`
+}
+
+type PDBSpec struct {
+	MinAvailable   interface{} `yaml:"minAvailable,omitempty"`
+	MaxUnavailable interface{} `yaml:"maxUnavailable,omitempty"`
+	Selector       Selector    `yaml:"selector"`
+}
+
+var (
+	pdbEnabled        bool
+	pdbEnabledSet     bool
+	pdbMinAvailable   string
+	pdbMaxUnavailable string
+)
+
+// PDBSection configures the PodDisruptionBudgets in the config file
+type PDBSection struct {
+	Enabled        *bool  `yaml:"enabled"`
+	MinAvailable   string `yaml:"minAvailable"`
+	MaxUnavailable string `yaml:"maxUnavailable"`
+}
+
+// Check the PDB settings before generating anything
+func resolvePDB() error {
+	if pdbMinAvailable != "" && pdbMaxUnavailable != "" {
+		return fmt.Errorf("set either --pdb-min-available or --pdb-max-unavailable, not both")
+	}
+	if pdbMinAvailable != "" {
+		if _, err := parseIntOrPercent(pdbMinAvailable); err != nil {
+			return fmt.Errorf("invalid --pdb-min-available %q: %w", pdbMinAvailable, err)
+		}
+	}
+	if pdbMaxUnavailable != "" {
+		if _, err := parseIntOrPercent(pdbMaxUnavailable); err != nil {
+			return fmt.Errorf("invalid --pdb-max-unavailable %q: %w", pdbMaxUnavailable, err)
+		}
+	}
+	return nil
+}
+
+// Check an environment's pdb section
+func checkPDBSection(section PDBSection) error {
+	if section.MinAvailable != "" && section.MaxUnavailable != "" {
+		return fmt.Errorf("set either pdb.minAvailable or pdb.maxUnavailable, not both")
+	}
+	if section.MinAvailable != "" {
+		if _, err := parseIntOrPercent(section.MinAvailable); err != nil {
+			return fmt.Errorf("invalid pdb.minAvailable %q: %w", section.MinAvailable, err)
+		}
+	}
+	if section.MaxUnavailable != "" {
+		if _, err := parseIntOrPercent(section.MaxUnavailable); err != nil {
+			return fmt.Errorf("invalid pdb.maxUnavailable %q: %w", section.MaxUnavailable, err)
+		}
+	}
+	return nil
+}
+
+// Whether an environment's own pdb section enables or sizes its budgets
+func environmentPDBConfigured(section PDBSection) bool {
+	return (section.Enabled != nil && *section.Enabled) || section.MinAvailable != "" || section.MaxUnavailable != ""
+}
+
+// Parse a count (2) or a percentage (50%) as it appears in the PDB spec
+func parseIntOrPercent(value string) (interface{}, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("expected a percentage between 0%% and 100%%")
+		}
+		return value, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("expected a non-negative number or a percentage")
+	}
+	return n, nil
+}
+
+// PDBs are generated when enabled or configured explicitly, and by default in
+// production. The environment's pdb section wins over the app-wide settings.
+func pdbEnabledFor(envName string) bool {
+	section := environmentConfigFor(envName).PDB
+	if section.Enabled != nil {
+		return *section.Enabled
+	}
+	if section.MinAvailable != "" || section.MaxUnavailable != "" {
+		return true
+	}
+	if pdbEnabledSet {
+		return pdbEnabled
+	}
+	return pdbEnabled || pdbMinAvailable != "" || pdbMaxUnavailable != "" || envName == "production"
+}
+
+// Fewest replicas a component runs with: the HPA minimum for the one it scales
+func minReplicas(c ComponentConfig) int {
+	if hpaEnabled && c.Name == primaryComponent().Name {
+		return hpaMinReplicas
+	}
+	return *c.Replicas
+}
+
+// Create PodDisruptionBudget resources for the components. Only explicitly
+// configured budgets cover single-replica components; the production default
+// skips them, as one pod cannot stay available during a drain anyway. An
+// app-wide budget that would block all evictions in this environment is
+// skipped with a warning; one from the environment's own pdb section fails.
+func createPDBs(envName, ns string) ([]*PodDisruptionBudget, error) {
+	section := environmentConfigFor(envName).PDB
+	own := environmentPDBConfigured(section)
+	configured := own || pdbEnabledSet || pdbEnabled || pdbMinAvailable != "" || pdbMaxUnavailable != ""
+
+	minAvailable, maxUnavailable := pdbMinAvailable, pdbMaxUnavailable
+	if section.MinAvailable != "" || section.MaxUnavailable != "" {
+		minAvailable, maxUnavailable = section.MinAvailable, section.MaxUnavailable
+	}
+
+	var pdbs []*PodDisruptionBudget
+	for _, c := range componentsFor(envName) {
+		replicas := minReplicas(c)
+		if replicas < 1 || (replicas == 1 && !configured) {
+			continue
+		}
+
+		spec := PDBSpec{Selector: Selector{MatchLabels: c.selectorLabels()}}
+		switch {
+		case minAvailable != "":
+			spec.MinAvailable, _ = parseIntOrPercent(minAvailable)
+		case maxUnavailable != "":
+			spec.MaxUnavailable, _ = parseIntOrPercent(maxUnavailable)
+		default:
+			spec.MaxUnavailable = 1
+		}
+		if err := checkPDBAllowsEvictions(spec, replicas); err != nil {
+			if own {
+				return nil, fmt.Errorf("PodDisruptionBudget for %s in %s would block all evictions: %w", c.deploymentName(), envName, err)
+			}
+			warnf("Skipping the PodDisruptionBudget for %s in %s, it would block all evictions: %v", c.deploymentName(), envName, err)
+			continue
+		}
+
+		pdbs = append(pdbs, &PodDisruptionBudget{
+			APIVersion: "policy/v1",
+			Kind:       "PodDisruptionBudget",
+			Metadata: Metadata{
+				Name:      fmt.Sprintf("%s-pdb", c.deploymentName()),
+				Namespace: ns,
+			},
+			Spec: spec,
+		})
+	}
+	return pdbs, nil
+}
+
+// Refuse budgets that never allow a disruption, since they make node drains
+// hang. Percentages are rounded up, as the disruption controller does.
+func checkPDBAllowsEvictions(spec PDBSpec, replicas int) error {
+	scaled := func(v interface{}) int {
+		switch value := v.(type) {
+		case int:
+			return value
+		case string:
+			percent, _ := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			return (percent*replicas + 99) / 100
+		}
+		return 0
+	}
+
+	if spec.MinAvailable != nil {
+		minAvailable := scaled(spec.MinAvailable)
+		if minAvailable == 0 {
+			return fmt.Errorf("minAvailable %v protects no pods; disable the PDB instead", spec.MinAvailable)
+		}
+		if minAvailable >= replicas {
+			return fmt.Errorf("minAvailable %v with %d replica(s); lower it or run more replicas", spec.MinAvailable, replicas)
+		}
+	}
+	if spec.MaxUnavailable != nil && scaled(spec.MaxUnavailable) == 0 {
+		return fmt.Errorf("maxUnavailable %v; allow at least one pod to be evicted", spec.MaxUnavailable)
+	}
+	return nil
+}
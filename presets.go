@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in presets, one YAML file per preset in the same format as user presets
+//
+//go:embed presets/*.yaml
+var builtinPresets embed.FS
+
+// Preset holds runtime defaults for a language or framework. Its values sit
+// below the config file and flags, which can override any of them.
+type Preset struct {
+	Description   string           `yaml:"description"`
+	Component     string           `yaml:"component"`
+	ContainerPort *int             `yaml:"containerPort"`
+	PortName      string           `yaml:"portName"`
+	Probes        PresetProbes     `yaml:"probes"`
+	Resources     ResourcesSection `yaml:"resources"`
+	Env           []EnvVarConfig   `yaml:"env"`
+	// Env vars of a single environment, e.g. NODE_ENV in production
+	EnvironmentEnv map[string][]EnvVarConfig `yaml:"environmentEnv"`
+	// Merged over the default hardening of the app container
+	SecurityContext    map[string]interface{} `yaml:"securityContext"`
+	PodSecurityContext map[string]interface{} `yaml:"podSecurityContext"`
+	Volumes            []Volume               `yaml:"volumes"`
+	VolumeMounts       []VolumeMount          `yaml:"volumeMounts"`
+	// Sidecars are only added to components that expose a port
+	Sidecars []ContainerConfig `yaml:"sidecars"`
+}
+
+type PresetProbes struct {
+	Liveness  *ProbeConfig `yaml:"liveness"`
+	Readiness *ProbeConfig `yaml:"readiness"`
+	Startup   *ProbeConfig `yaml:"startup"`
+}
+
+var (
+	presetName   string
+	presetDir    string
+	activePreset *Preset
+)
+
+// Preset names double as file names and component names
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Directory searched for user presets when --preset-dir is not given
+func defaultPresetDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kcg", "presets")
+}
+
+// Load a preset by name; user presets shadow built-in ones of the same name
+func loadPreset(name, dir string) (*Preset, error) {
+	if !presetNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid preset name %q", name)
+	}
+	if dir == "" {
+		dir = defaultPresetDir()
+	}
+	if dir != "" {
+		for _, ext := range []string{".yaml", ".yml"} {
+			path := filepath.Join(dir, name+ext)
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read preset: %w", err)
+			}
+			preset, err := parsePreset(data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid preset %s: %w", path, err)
+			}
+			return preset, nil
+		}
+	}
+
+	data, err := builtinPresets.ReadFile("presets/" + name + ".yaml")
+	if err != nil {
+		names, _ := presetNames(dir)
+		return nil, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return parsePreset(data)
+}
+
+// Parse a preset file, rejecting unknown fields
+func parsePreset(data []byte) (*Preset, error) {
+	var preset Preset
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&preset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("preset is empty")
+		}
+		return nil, err
+	}
+
+	if preset.Component != "" && !presetNamePattern.MatchString(preset.Component) {
+		return nil, fmt.Errorf("component %q must be a lowercase DNS label", preset.Component)
+	}
+	if preset.ContainerPort != nil && (*preset.ContainerPort < 1 || *preset.ContainerPort > 65535) {
+		return nil, fmt.Errorf("invalid containerPort %d", *preset.ContainerPort)
+	}
+
+	// The Service targets http-port, so another container has to provide it
+	if preset.PortName != "" && preset.PortName != "http-port" {
+		if len(preset.PortName) > 15 || !presetNamePattern.MatchString(preset.PortName) {
+			return nil, fmt.Errorf("invalid portName %q", preset.PortName)
+		}
+		found := false
+		for _, sidecar := range preset.Sidecars {
+			for _, port := range sidecar.Ports {
+				found = found || port.Name == "http-port"
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("portName %s requires a sidecar exposing a port named http-port for the Service", preset.PortName)
+		}
+	}
+	return &preset, nil
+}
+
+// Names of the built-in presets and the ones in dir, sorted
+func presetNames(dir string) ([]string, error) {
+	seen := map[string]bool{}
+	entries, err := builtinPresets.ReadDir("presets")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		seen[strings.TrimSuffix(entry.Name(), ".yaml")] = true
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read preset directory: %w", err)
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			seen[strings.TrimSuffix(entry.Name(), ext)] = true
+		}
+	}
+
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load the selected preset and apply its port and resources to every flag
+// not set on the command line; the config file is applied on top afterwards
+func applyPreset(changed func(name string) bool) error {
+	activePreset = nil
+	if presetName == "" {
+		return nil
+	}
+	preset, err := loadPreset(presetName, presetDir)
+	if err != nil {
+		return err
+	}
+	activePreset = preset
+
+	a := configApplier{changed: changed}
+	a.int("container-port", &containerPort, preset.ContainerPort)
+	a.str("resources-requests-cpu", &resourceRequestsCPU, preset.Resources.Requests.CPU)
+	a.str("resources-requests-memory", &resourceRequestsMemory, preset.Resources.Requests.Memory)
+	a.str("resources-limits-cpu", &resourceLimitsCPU, preset.Resources.Limits.CPU)
+	a.str("resources-limits-memory", &resourceLimitsMemory, preset.Resources.Limits.Memory)
+	return nil
+}
+
+// Name of the default component: the preset's, or node
+func defaultComponentName() string {
+	if activePreset != nil && activePreset.Component != "" {
+		return activePreset.Component
+	}
+	return "node"
+}
+
+// Name of the app container port; probes default to it
+func appPortName() string {
+	if activePreset != nil && activePreset.PortName != "" {
+		return activePreset.PortName
+	}
+	return "http-port"
+}
+
+// Probe from the preset, used when neither flags nor the config file set one
+func runtimePresetProbe(name string) *ProbeConfig {
+	if activePreset == nil {
+		return nil
+	}
+	switch name {
+	case "liveness":
+		return activePreset.Probes.Liveness
+	case "readiness":
+		return activePreset.Probes.Readiness
+	case "startup":
+		return activePreset.Probes.Startup
+	}
+	return nil
+}
+
+// Environment variables from the preset, the base for everything else; ""
+// gives the ones shared by every environment
+func presetEnvVars(envName string) []EnvVarConfig {
+	if activePreset == nil {
+		return nil
+	}
+	if envName == "" {
+		return activePreset.Env
+	}
+	return activePreset.EnvironmentEnv[envName]
+}
+
+// Add the preset's volumes, mounts and sidecars to a component; entries the
+// component declares with the same name (or mount path) replace them
+func withPresetContainers(c ComponentConfig) ComponentConfig {
+	if activePreset == nil {
+		return c
+	}
+
+	var volumes []Volume
+	for _, v := range activePreset.Volumes {
+		declared := false
+		for _, own := range c.Volumes {
+			declared = declared || own.Name == v.Name
+		}
+		if !declared {
+			volumes = append(volumes, v)
+		}
+	}
+	c.Volumes = append(volumes, c.Volumes...)
+
+	var mounts []VolumeMount
+	for _, m := range activePreset.VolumeMounts {
+		declared := false
+		for _, own := range c.VolumeMounts {
+			declared = declared || own.MountPath == m.MountPath
+		}
+		if !declared {
+			mounts = append(mounts, m)
+		}
+	}
+	c.VolumeMounts = append(mounts, c.VolumeMounts...)
+
+	if *c.Port > 0 {
+		var sidecars []ContainerConfig
+		for _, s := range activePreset.Sidecars {
+			declared := false
+			for _, own := range c.Sidecars {
+				declared = declared || own.Name == s.Name
+			}
+			if !declared {
+				sidecars = append(sidecars, s)
+			}
+		}
+		c.Sidecars = append(sidecars, c.Sidecars...)
+	}
+	return c
+}
+
+// Security context of the app container: the default hardening with the
+// preset's settings on top
+func appSecurityContext() map[string]interface{} {
+	securityContext := containerSecurityContext()
+	if activePreset != nil {
+		for k, v := range activePreset.SecurityContext {
+			securityContext[k] = v
+		}
+	}
+	return securityContext
+}
+
+func podSecurityContext() map[string]interface{} {
+	if activePreset == nil {
+		return nil
+	}
+	return activePreset.PodSecurityContext
+}
+
+func newPresetsCommand() *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:   "presets",
+		Short: "List the built-in and user-defined presets",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir == "" {
+				dir = defaultPresetDir()
+			}
+			names, err := presetNames(dir)
+			if err != nil {
+				return err
+			}
+
+			rows := [][]string{{"NAME", "COMPONENT", "PORT", "DESCRIPTION"}}
+			for _, name := range names {
+				preset, err := loadPreset(name, dir)
+				if err != nil {
+					return err
+				}
+				port := ""
+				if preset.ContainerPort != nil {
+					port = fmt.Sprint(*preset.ContainerPort)
+				}
+				rows = append(rows, []string{name, preset.Component, port, preset.Description})
+			}
+			return pterm.DefaultTable.WithHasHeader().WithData(rows).Render()
+		},
+	}
+	cmd.Flags().StringVar(&dir, "preset-dir", "", "Directory with user presets (default: $XDG_CONFIG_HOME/kcg/presets)")
+	return cmd
+}
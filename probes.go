@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProbeConfig describes a liveness, readiness or startup probe
+type ProbeConfig struct {
+	Type                string   `yaml:"type"`
+	Path                string   `yaml:"path,omitempty"`
+	Port                string   `yaml:"port,omitempty"`
+	Scheme              string   `yaml:"scheme,omitempty"`
+	Command             []string `yaml:"command,omitempty"`
+	Service             string   `yaml:"service,omitempty"`
+	InitialDelaySeconds *int     `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       *int     `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      *int     `yaml:"timeoutSeconds,omitempty"`
+	SuccessThreshold    *int     `yaml:"successThreshold,omitempty"`
+	FailureThreshold    *int     `yaml:"failureThreshold,omitempty"`
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// Probe presets for the health endpoints our services expose
+var probePresets = map[string]map[string]ProbeConfig{
+	"node": {
+		"liveness": {
+			Type:             "http",
+			Path:             "/healthz",
+			PeriodSeconds:    intPtr(10),
+			FailureThreshold: intPtr(3),
+		},
+		"readiness": {
+			Type:             "http",
+			Path:             "/ready",
+			PeriodSeconds:    intPtr(5),
+			FailureThreshold: intPtr(3),
+		},
+		"startup": {
+			Type:             "http",
+			Path:             "/healthz",
+			PeriodSeconds:    intPtr(5),
+			FailureThreshold: intPtr(30),
+		},
+	},
+}
+
+// Resolve liveness, readiness and startup probes from flags, config file and presets.
+// Flags win over the config file, which wins over the probe preset and then
+// the runtime preset.
+func resolveProbes() error {
+	var preset map[string]ProbeConfig
+	if probePreset != "" && probePreset != "none" {
+		p, ok := probePresets[probePreset]
+		if !ok {
+			return fmt.Errorf("unknown probe preset %q (available: node, none)", probePreset)
+		}
+		preset = p
+	}
+
+	probes := []struct {
+		name   string
+		spec   string
+		config **ProbeConfig
+	}{
+		{"liveness", livenessProbeSpec, &livenessProbeConfig},
+		{"readiness", readinessProbeSpec, &readinessProbeConfig},
+		{"startup", startupProbeSpec, &startupProbeConfig},
+	}
+
+	for _, p := range probes {
+		if p.spec != "" {
+			probe, err := parseProbeSpec(p.spec)
+			if err != nil {
+				return fmt.Errorf("invalid --%s-probe %q: %w", p.name, p.spec, err)
+			}
+			*p.config = probe
+		} else if *p.config == nil {
+			if presetProbe, ok := preset[p.name]; ok {
+				*p.config = &presetProbe
+			} else if probePreset != "none" {
+				// --probe-preset none also drops the runtime preset's probes
+				*p.config = runtimePresetProbe(p.name)
+			}
+		}
+
+		if *p.config != nil {
+			if err := validateProbe(p.name, *p.config); err != nil {
+				return fmt.Errorf("invalid %s probe: %w", p.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Parse a probe flag of the form TYPE[:TARGET][,key=value...], e.g.
+// http:/healthz,initialDelaySeconds=10 or tcp:5432 or exec:cat /tmp/healthy
+func parseProbeSpec(spec string) (*ProbeConfig, error) {
+	parts := strings.Split(spec, ",")
+	probe := &ProbeConfig{}
+
+	head := strings.SplitN(parts[0], ":", 2)
+	probe.Type = head[0]
+	if len(head) == 2 {
+		switch probe.Type {
+		case "http":
+			probe.Path = head[1]
+		case "tcp", "grpc":
+			probe.Port = head[1]
+		case "exec":
+			probe.Command = strings.Fields(head[1])
+		}
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected key=value, got %q", opt)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch key {
+		case "path":
+			probe.Path = value
+		case "port":
+			probe.Port = value
+		case "scheme":
+			probe.Scheme = value
+		case "service":
+			probe.Service = value
+		case "initialDelaySeconds", "periodSeconds", "timeoutSeconds", "successThreshold", "failureThreshold":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			switch key {
+			case "initialDelaySeconds":
+				probe.InitialDelaySeconds = &n
+			case "periodSeconds":
+				probe.PeriodSeconds = &n
+			case "timeoutSeconds":
+				probe.TimeoutSeconds = &n
+			case "successThreshold":
+				probe.SuccessThreshold = &n
+			case "failureThreshold":
+				probe.FailureThreshold = &n
+			}
+		default:
+			return nil, fmt.Errorf("unknown probe option %q", key)
+		}
+	}
+
+	return probe, nil
+}
+
+func validateProbe(name string, probe *ProbeConfig) error {
+	switch probe.Type {
+	case "http":
+		if probe.Path == "" {
+			return fmt.Errorf("http probe requires a path")
+		}
+		if probe.Scheme != "" && probe.Scheme != "HTTP" && probe.Scheme != "HTTPS" {
+			return fmt.Errorf("scheme must be HTTP or HTTPS")
+		}
+	case "tcp":
+	case "exec":
+		if len(probe.Command) == 0 {
+			return fmt.Errorf("exec probe requires a command")
+		}
+	case "grpc":
+		if probe.Port != "" {
+			if _, err := strconv.Atoi(probe.Port); err != nil {
+				return fmt.Errorf("grpc probe port must be a number")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown probe type %q (expected http, tcp, exec or grpc)", probe.Type)
+	}
+
+	// Kubernetes rejects liveness and startup probes with successThreshold other than 1
+	if name != "readiness" && probe.SuccessThreshold != nil && *probe.SuccessThreshold != 1 {
+		return fmt.Errorf("successThreshold must be 1 for %s probes", name)
+	}
+
+	for _, v := range []*int{probe.InitialDelaySeconds, probe.PeriodSeconds, probe.TimeoutSeconds, probe.SuccessThreshold, probe.FailureThreshold} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("probe timings and thresholds must not be negative")
+		}
+	}
+	return nil
+}
+
+// Build the probe as it appears in the container spec; port is the port of
+// the container the probe belongs to
+func buildProbe(probe *ProbeConfig, port int) map[string]interface{} {
+	if probe == nil {
+		return nil
+	}
+
+	result := make(map[string]interface{})
+
+	switch probe.Type {
+	case "http":
+		httpGet := map[string]interface{}{
+			"path": probe.Path,
+			"port": probePort(probe.Port),
+		}
+		if probe.Scheme != "" {
+			httpGet["scheme"] = probe.Scheme
+		}
+		result["httpGet"] = httpGet
+	case "tcp":
+		result["tcpSocket"] = map[string]interface{}{
+			"port": probePort(probe.Port),
+		}
+	case "exec":
+		result["exec"] = map[string]interface{}{
+			"command": probe.Command,
+		}
+	case "grpc":
+		// gRPC probes only accept a port number
+		if probe.Port != "" {
+			port, _ = strconv.Atoi(probe.Port)
+		}
+		grpc := map[string]interface{}{
+			"port": port,
+		}
+		if probe.Service != "" {
+			grpc["service"] = probe.Service
+		}
+		result["grpc"] = grpc
+	}
+
+	if probe.InitialDelaySeconds != nil {
+		result["initialDelaySeconds"] = *probe.InitialDelaySeconds
+	}
+	if probe.PeriodSeconds != nil {
+		result["periodSeconds"] = *probe.PeriodSeconds
+	}
+	if probe.TimeoutSeconds != nil {
+		result["timeoutSeconds"] = *probe.TimeoutSeconds
+	}
+	if probe.SuccessThreshold != nil {
+		result["successThreshold"] = *probe.SuccessThreshold
+	}
+	if probe.FailureThreshold != nil {
+		result["failureThreshold"] = *probe.FailureThreshold
+	}
+
+	return result
+}
+
+// Probe ports default to the named container port; numbers stay numbers
+func probePort(port string) interface{} {
+	if port == "" {
+		return appPortName()
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		return n
+	}
+	return port
+}
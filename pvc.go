@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PersistentVolumeClaim struct {
+	APIVersion string                    `yaml:"apiVersion"`
+	Kind       string                    `yaml:"kind"`
+	Metadata   Metadata                  `yaml:"metadata"`
+	Spec       PersistentVolumeClaimSpec `yaml:"spec"`
+}
+
+// PersistentVolumeClaimConfig declares a standalone claim for pod volumes to
+// refer to by name
+type PersistentVolumeClaimConfig struct {
+	Name string `yaml:"name"`
+	Size string `yaml:"size"`
+	// Per-environment sizes replacing size
+	Sizes        map[string]string `yaml:"sizes"`
+	StorageClass string            `yaml:"storageClass"`
+	AccessModes  []string          `yaml:"accessModes"`
+	VolumeMode   string            `yaml:"volumeMode"`
+}
+
+var pvcConfigs []PersistentVolumeClaimConfig
+
+// Size of a claim in an environment
+func (p PersistentVolumeClaimConfig) sizeFor(envName string) (string, error) {
+	if size, ok := p.Sizes[envName]; ok {
+		return size, nil
+	}
+	if p.Size == "" {
+		return "", fmt.Errorf("persistent volume claim %s has no size for %s (set size or sizes.%s)", p.Name, environmentLabel(envName), envName)
+	}
+	return p.Size, nil
+}
+
+// Check the claims from the config file before generating anything
+func resolvePersistentVolumeClaims() error {
+	names := map[string]bool{}
+	for _, p := range pvcConfigs {
+		if !dns1123SubdomainPattern.MatchString(p.Name) {
+			return fmt.Errorf("persistent volume claim %q: name must be a lowercase DNS name", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate persistent volume claim %q", p.Name)
+		}
+		names[p.Name] = true
+
+		for envName, size := range p.Sizes {
+			if !knownEnvironment(envName) {
+				return fmt.Errorf("persistent volume claim %s: unknown environment %q in sizes (defined: %s)", p.Name, envName, strings.Join(environmentNames, ", "))
+			}
+			if size == "" {
+				return fmt.Errorf("persistent volume claim %s: empty size for %s", p.Name, environmentLabel(envName))
+			}
+		}
+		for _, mode := range p.AccessModes {
+			if !containsString(pvcAccessModes, mode) {
+				return fmt.Errorf("persistent volume claim %s: invalid access mode %q (expected %s)", p.Name, mode, strings.Join(pvcAccessModes, ", "))
+			}
+		}
+		if p.VolumeMode != "" && p.VolumeMode != "Filesystem" && p.VolumeMode != "Block" {
+			return fmt.Errorf("persistent volume claim %s: volumeMode must be Filesystem or Block", p.Name)
+		}
+	}
+	return nil
+}
+
+// Create PersistentVolumeClaim resource; claims default to ReadWriteOnce and
+// the cluster's default storage class
+func createPersistentVolumeClaim(p PersistentVolumeClaimConfig, envName, ns string) (*PersistentVolumeClaim, error) {
+	size, err := p.sizeFor(envName)
+	if err != nil {
+		return nil, err
+	}
+	accessModes := p.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []string{"ReadWriteOnce"}
+	}
+
+	return &PersistentVolumeClaim{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Metadata: Metadata{
+			Name:      p.Name,
+			Namespace: ns,
+		},
+		Spec: PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: p.StorageClass,
+			VolumeMode:       p.VolumeMode,
+			Resources: VolumeResourceValues{
+				Requests: map[string]string{"storage": size},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchedulingConfig holds node selection, affinity, tolerations and topology spread settings
+type SchedulingConfig struct {
+	Presets                   []string                 `yaml:"presets"`
+	NodeSelector              map[string]string        `yaml:"nodeSelector"`
+	Affinity                  map[string]interface{}   `yaml:"affinity"`
+	Tolerations               []map[string]interface{} `yaml:"tolerations"`
+	TopologySpreadConstraints []map[string]interface{} `yaml:"topologySpreadConstraints"`
+}
+
+// Taints put on spot/preemptible nodes: AKS taints spot pools itself, GKE
+// uses these keys when a pool is tainted. Karpenter and EKS only label spot
+// nodes, so there is nothing to tolerate there.
+var spotTaintKeys = []string{
+	"cloud.google.com/gke-spot",
+	"cloud.google.com/gke-preemptible",
+	"kubernetes.azure.com/scalesetpriority",
+}
+
+var schedulingPresetNames = []string{"spread-zones", "spread-hosts", "anti-affinity-hostname", "tolerate-spot"}
+
+// Check the scheduling presets and flags before generating anything
+func resolveScheduling() error {
+	_, err := buildScheduling(nil)
+	return err
+}
+
+// Merge scheduling presets, config and flags for pods with the given
+// selector labels. Flags are applied last.
+func buildScheduling(labels map[string]string) (SchedulingConfig, error) {
+	resolved := SchedulingConfig{
+		NodeSelector: map[string]string{},
+		Affinity:     map[string]interface{}{},
+	}
+	for k, v := range schedulingConfig.NodeSelector {
+		resolved.NodeSelector[k] = v
+	}
+	for k, v := range schedulingConfig.Affinity {
+		resolved.Affinity[k] = v
+	}
+	resolved.Tolerations = append(resolved.Tolerations, schedulingConfig.Tolerations...)
+	resolved.TopologySpreadConstraints = append(resolved.TopologySpreadConstraints, schedulingConfig.TopologySpreadConstraints...)
+
+	presets := append(append([]string{}, schedulingConfig.Presets...), schedulingPresets...)
+	for _, preset := range presets {
+		switch preset {
+		case "spread-zones":
+			resolved.TopologySpreadConstraints = append(resolved.TopologySpreadConstraints, topologySpread("topology.kubernetes.io/zone", labels))
+		case "spread-hosts":
+			resolved.TopologySpreadConstraints = append(resolved.TopologySpreadConstraints, topologySpread("kubernetes.io/hostname", labels))
+		case "anti-affinity-hostname":
+			addPreferredAntiAffinity(resolved.Affinity, map[string]interface{}{
+				"weight": 100,
+				"podAffinityTerm": map[string]interface{}{
+					"labelSelector": map[string]interface{}{
+						"matchLabels": labels,
+					},
+					"topologyKey": "kubernetes.io/hostname",
+				},
+			})
+		case "tolerate-spot":
+			for _, key := range spotTaintKeys {
+				resolved.Tolerations = append(resolved.Tolerations, map[string]interface{}{
+					"key":      key,
+					"operator": "Exists",
+					"effect":   "NoSchedule",
+				})
+			}
+		default:
+			return SchedulingConfig{}, fmt.Errorf("unknown scheduling preset %q (available: %s)", preset, schedulingPresetList())
+		}
+	}
+
+	for _, spec := range nodeSelectorFlags {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return SchedulingConfig{}, fmt.Errorf("invalid --node-selector %q: expected KEY=VALUE", spec)
+		}
+		resolved.NodeSelector[kv[0]] = kv[1]
+	}
+
+	for _, spec := range tolerationFlags {
+		toleration, err := parseToleration(spec)
+		if err != nil {
+			return SchedulingConfig{}, fmt.Errorf("invalid --toleration %q: %w", spec, err)
+		}
+		resolved.Tolerations = append(resolved.Tolerations, toleration)
+	}
+
+	return resolved, nil
+}
+
+// Set scheduling fields on a pod spec; presets select pods by labels
+func applyScheduling(spec *PodSpec, labels map[string]string) {
+	// Errors were reported by resolveScheduling
+	resolved, _ := buildScheduling(labels)
+	if len(resolved.NodeSelector) > 0 {
+		spec.NodeSelector = resolved.NodeSelector
+	}
+	if len(resolved.Affinity) > 0 {
+		spec.Affinity = resolved.Affinity
+	}
+	spec.Tolerations = resolved.Tolerations
+	spec.TopologySpreadConstraints = resolved.TopologySpreadConstraints
+}
+
+func topologySpread(topologyKey string, labels map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"maxSkew":           1,
+		"topologyKey":       topologyKey,
+		"whenUnsatisfiable": "ScheduleAnyway",
+		"labelSelector": map[string]interface{}{
+			"matchLabels": labels,
+		},
+	}
+}
+
+// Append a preferred pod anti-affinity term, keeping any terms from the config file
+func addPreferredAntiAffinity(affinity map[string]interface{}, term map[string]interface{}) {
+	// Copy, so the config file's affinity is never modified
+	antiAffinity := map[string]interface{}{}
+	if existing, ok := affinity["podAntiAffinity"].(map[string]interface{}); ok {
+		for k, v := range existing {
+			antiAffinity[k] = v
+		}
+	}
+	preferred, _ := antiAffinity["preferredDuringSchedulingIgnoredDuringExecution"].([]interface{})
+	antiAffinity["preferredDuringSchedulingIgnoredDuringExecution"] = append(append([]interface{}{}, preferred...), term)
+	affinity["podAntiAffinity"] = antiAffinity
+}
+
+// Parse KEY[=VALUE][:EFFECT]. Without a value the toleration uses the Exists operator,
+// without an effect it tolerates all effects.
+func parseToleration(spec string) (map[string]interface{}, error) {
+	toleration := map[string]interface{}{}
+
+	keyValue := spec
+	if idx := strings.LastIndex(spec, ":"); idx >= 0 {
+		keyValue = spec[:idx]
+		effect := spec[idx+1:]
+		switch effect {
+		case "NoSchedule", "PreferNoSchedule", "NoExecute":
+			toleration["effect"] = effect
+		default:
+			return nil, fmt.Errorf("invalid effect %q (expected NoSchedule, PreferNoSchedule or NoExecute)", effect)
+		}
+	}
+
+	kv := strings.SplitN(keyValue, "=", 2)
+	if kv[0] == "" {
+		return nil, fmt.Errorf("expected KEY[=VALUE][:EFFECT]")
+	}
+	toleration["key"] = kv[0]
+	if len(kv) == 2 {
+		toleration["operator"] = "Equal"
+		toleration["value"] = kv[1]
+	} else {
+		toleration["operator"] = "Exists"
+	}
+	return toleration, nil
+}
+
+// Sorted scheduling preset names for help output
+func schedulingPresetList() string {
+	names := append([]string{}, schedulingPresetNames...)
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// SopsSecret is the app Secret encrypted with sops for age recipients. It is
+// encrypted when marshaled, so the MAC covers the metadata as written, e.g.
+// after kustomize dropped the namespace.
+type SopsSecret struct {
+	Secret     *Secret
+	recipients []string
+}
+
+type sopsMetadata struct {
+	Age            []sopsAgeKey `yaml:"age"`
+	LastModified   string       `yaml:"lastmodified"`
+	MAC            string       `yaml:"mac"`
+	EncryptedRegex string       `yaml:"encrypted_regex"`
+	Version        string       `yaml:"version"`
+}
+
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+type SealedSecret struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   Metadata         `yaml:"metadata"`
+	Spec       SealedSecretSpec `yaml:"spec"`
+}
+
+type SealedSecretSpec struct {
+	EncryptedData map[string]string `yaml:"encryptedData"`
+	Template      SecretTemplate    `yaml:"template"`
+}
+
+type SecretTemplate struct {
+	Metadata Metadata `yaml:"metadata"`
+	Type     string   `yaml:"type"`
+}
+
+type ExternalSecret struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Kind       string             `yaml:"kind"`
+	Metadata   Metadata           `yaml:"metadata"`
+	Spec       ExternalSecretSpec `yaml:"spec"`
+}
+
+type ExternalSecretSpec struct {
+	RefreshInterval string                   `yaml:"refreshInterval"`
+	SecretStoreRef  SecretStoreRef           `yaml:"secretStoreRef"`
+	Target          ExternalSecretTarget     `yaml:"target"`
+	Data            []ExternalSecretData     `yaml:"data,omitempty"`
+	DataFrom        []ExternalSecretDataFrom `yaml:"dataFrom,omitempty"`
+}
+
+type SecretStoreRef struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+}
+
+type ExternalSecretTarget struct {
+	Name           string `yaml:"name"`
+	CreationPolicy string `yaml:"creationPolicy"`
+}
+
+type ExternalSecretData struct {
+	SecretKey string            `yaml:"secretKey"`
+	RemoteRef ExternalSecretRef `yaml:"remoteRef"`
+}
+
+type ExternalSecretDataFrom struct {
+	Extract ExternalSecretRef `yaml:"extract"`
+}
+
+type ExternalSecretRef struct {
+	Key      string `yaml:"key"`
+	Property string `yaml:"property,omitempty"`
+}
+
+// SecretsSection configures how the app Secret is written in the config file
+type SecretsSection struct {
+	Format          string                `yaml:"format"`
+	Sops            SopsConfig            `yaml:"sops"`
+	SealedSecrets   SealedSecretsConfig   `yaml:"sealedSecrets"`
+	ExternalSecrets ExternalSecretsConfig `yaml:"externalSecrets"`
+}
+
+type SopsConfig struct {
+	AgeRecipients []string `yaml:"ageRecipients"`
+}
+
+type SealedSecretsConfig struct {
+	Cert  string `yaml:"cert"`
+	Scope string `yaml:"scope"`
+}
+
+type ExternalSecretsConfig struct {
+	Store           string `yaml:"store"`
+	StoreKind       string `yaml:"storeKind"`
+	RemoteKey       string `yaml:"remoteKey"`
+	RefreshInterval string `yaml:"refreshInterval"`
+}
+
+var (
+	secretFormat                   string
+	sopsAgeRecipients              []string
+	sealedSecretsCert              string
+	sealedSecretsScope             string
+	externalSecretsStore           string
+	externalSecretsStoreKind       string
+	externalSecretsRemoteKey       string
+	externalSecretsRefreshInterval string
+)
+
+// Public key of the sealed-secrets controller, read from --sealed-secrets-cert
+var sealedSecretsKey *rsa.PublicKey
+
+var (
+	secretFormats      = []string{"plain", "sops", "sealed-secrets", "external-secrets"}
+	sealedSecretScopes = []string{"strict", "namespace-wide", "cluster-wide"}
+	secretStoreKinds   = []string{"SecretStore", "ClusterSecretStore"}
+)
+
+const (
+	sopsVersion = "3.8.1"
+	// Only the Secret values are encrypted; names and labels stay readable
+	sopsEncryptedRegex = "^(data|stringData)$"
+)
+
+// Check the secret format settings and load the keys it encrypts with. All
+// formats work offline: nothing is fetched from a cluster or a key service.
+func resolveSecretFormat() error {
+	if !containsString(secretFormats, secretFormat) {
+		return fmt.Errorf("invalid --secret-format %q (expected %s)", secretFormat, strings.Join(secretFormats, ", "))
+	}
+	if secretDataEncoding == "base64" && (secretFormat == "sealed-secrets" || secretFormat == "external-secrets") {
+		return fmt.Errorf("--secret-data-encoding base64 does not apply to --secret-format %s", secretFormat)
+	}
+
+	switch secretFormat {
+	case "sops":
+		if len(sopsAgeRecipients) == 0 {
+			return fmt.Errorf("--secret-format sops needs at least one --sops-age-recipient")
+		}
+		for _, recipient := range sopsAgeRecipients {
+			if _, err := age.ParseX25519Recipient(recipient); err != nil {
+				return fmt.Errorf("invalid --sops-age-recipient %q: %w", recipient, err)
+			}
+		}
+	case "sealed-secrets":
+		if sealedSecretsCert == "" {
+			return fmt.Errorf("--secret-format sealed-secrets needs --sealed-secrets-cert (fetch it once with kubeseal --fetch-cert)")
+		}
+		if !containsString(sealedSecretScopes, sealedSecretsScope) {
+			return fmt.Errorf("invalid --sealed-secrets-scope %q (expected strict, namespace-wide or cluster-wide)", sealedSecretsScope)
+		}
+		key, err := readSealedSecretsCert(sealedSecretsCert)
+		if err != nil {
+			return fmt.Errorf("invalid --sealed-secrets-cert %s: %w", sealedSecretsCert, err)
+		}
+		sealedSecretsKey = key
+	case "external-secrets":
+		if externalSecretsStore == "" {
+			return fmt.Errorf("--secret-format external-secrets needs --external-secrets-store")
+		}
+		if !containsString(secretStoreKinds, externalSecretsStoreKind) {
+			return fmt.Errorf("invalid --external-secrets-store-kind %q (expected SecretStore or ClusterSecretStore)", externalSecretsStoreKind)
+		}
+		if _, err := time.ParseDuration(externalSecretsRefreshInterval); err != nil {
+			return fmt.Errorf("invalid --external-secrets-refresh-interval %q: expected a duration such as 1h", externalSecretsRefreshInterval)
+		}
+	}
+	return nil
+}
+
+// Read the RSA public key from the controller certificate kubeseal fetches
+func readSealedSecretsCert(file string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("expected a PEM encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an RSA certificate")
+	}
+	return key, nil
+}
+
+// The app Secret in the format chosen with --secret-format
+func createSecretManifest(envName, ns string, values map[string]string) (interface{}, error) {
+	secret := createSecret(envName, ns, values)
+	switch secretFormat {
+	case "sops":
+		return &SopsSecret{Secret: secret, recipients: sopsAgeRecipients}, nil
+	case "sealed-secrets":
+		return createSealedSecret(secret)
+	case "external-secrets":
+		return createExternalSecret(envName, ns, values), nil
+	}
+	return secret, nil
+}
+
+func (s *SopsSecret) MarshalYAML() (interface{}, error) {
+	var doc yaml.Node
+	if err := doc.Encode(s.Secret); err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+
+	mac := sha512.New()
+	encryptedRegex := regexp.MustCompile(sopsEncryptedRegex)
+	if err := sopsEncryptNode(&doc, nil, false, encryptedRegex, dataKey, mac); err != nil {
+		return nil, err
+	}
+	encryptedMAC, err := sopsEncrypt(fmt.Sprintf("%X", mac.Sum(nil)), dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := sopsMetadata{
+		LastModified:   lastModified,
+		MAC:            encryptedMAC,
+		EncryptedRegex: sopsEncryptedRegex,
+		Version:        sopsVersion,
+	}
+	for _, recipient := range s.recipients {
+		enc, err := ageEncrypt(recipient, dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt the sops data key for %s: %w", recipient, err)
+		}
+		metadata.Age = append(metadata.Age, sopsAgeKey{Recipient: recipient, Enc: enc})
+	}
+
+	var metadataNode yaml.Node
+	if err := metadataNode.Encode(metadata); err != nil {
+		return nil, err
+	}
+	doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "sops"}, &metadataNode)
+	return &doc, nil
+}
+
+// Encrypt the values under keys matching the encrypted regex in place, and
+// hash every value in document order for the MAC, as sops does. Secrets only
+// hold strings, so every value is encrypted with type str.
+func sopsEncryptNode(node *yaml.Node, path []string, encrypted bool, encryptedRegex *regexp.Regexp, key []byte, mac hash.Hash) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			matched := encrypted || encryptedRegex.MatchString(name)
+			if err := sopsEncryptNode(node.Content[i+1], append(path, name), matched, encryptedRegex, key, mac); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := sopsEncryptNode(item, path, encrypted, encryptedRegex, key, mac); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		mac.Write([]byte(node.Value))
+		// sops leaves empty values empty
+		if !encrypted || node.Value == "" {
+			return nil
+		}
+		value, err := sopsEncrypt(node.Value, key, strings.Join(path, ":")+":")
+		if err != nil {
+			return err
+		}
+		node.Value = value
+		node.Tag = "!!str"
+		node.Style = 0
+	}
+	return nil
+}
+
+// Encrypt a string with AES-GCM the way sops does: a 32-byte IV and the
+// value's path as additional data
+func sopsEncrypt(plaintext string, key []byte, additionalData string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag)), nil
+}
+
+// Encrypt the sops data key for an age recipient, armored as sops stores it
+func ageEncrypt(recipient string, dataKey []byte) (string, error) {
+	parsed, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armored, parsed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Create SealedSecret resource, sealing each value for the controller. The
+// scope decides whether the Secret may be renamed or moved to another namespace.
+func createSealedSecret(secret *Secret) (*SealedSecret, error) {
+	ns := secret.Metadata.Namespace
+	if ns == "" && sealedSecretsScope != "cluster-wide" {
+		return nil, fmt.Errorf("--secret-format sealed-secrets needs a namespace to seal for (set --namespace or --sealed-secrets-scope cluster-wide)")
+	}
+
+	var label string
+	var annotations map[string]string
+	switch sealedSecretsScope {
+	case "strict":
+		label = ns + "/" + secret.Metadata.Name
+	case "namespace-wide":
+		label = ns
+		annotations = map[string]string{"sealedsecrets.bitnami.com/namespace-wide": "true"}
+	case "cluster-wide":
+		annotations = map[string]string{"sealedsecrets.bitnami.com/cluster-wide": "true"}
+	}
+
+	encryptedData := make(map[string]string, len(secret.StringData))
+	for key, value := range secret.StringData {
+		sealed, err := hybridEncrypt(sealedSecretsKey, []byte(value), []byte(label))
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal %s: %w", key, err)
+		}
+		encryptedData[key] = base64.StdEncoding.EncodeToString(sealed)
+	}
+
+	metadata := Metadata{
+		Name:        secret.Metadata.Name,
+		Namespace:   ns,
+		Annotations: annotations,
+	}
+	return &SealedSecret{
+		APIVersion: "bitnami.com/v1alpha1",
+		Kind:       "SealedSecret",
+		Metadata:   metadata,
+		Spec: SealedSecretSpec{
+			EncryptedData: encryptedData,
+			Template: SecretTemplate{
+				Metadata: metadata,
+				Type:     secret.Type,
+			},
+		},
+	}, nil
+}
+
+// Seal a value as the sealed-secrets controller expects: a random AES-256
+// session key wrapped with RSA-OAEP, followed by the AES-GCM ciphertext
+func hybridEncrypt(key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(wrappedKey)+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(wrappedKey)))
+	out = append(out, wrappedKey...)
+	// Each session key seals a single value, so the nonce can be zero
+	return gcm.Seal(out, make([]byte, gcm.NonceSize()), plaintext, nil), nil
+}
+
+// Remote key of the app's secret in the store; {env} is replaced by the
+// environment name
+func externalSecretRemoteKey(envName string) string {
+	if externalSecretsRemoteKey != "" {
+		return strings.ReplaceAll(externalSecretsRemoteKey, "{env}", envName)
+	}
+	if envName == "" {
+		return appName
+	}
+	return appName + "/" + envName
+}
+
+// Create ExternalSecret resource syncing the app Secret from a secret store.
+// Only the keys of the secret values are used; without any, every property of
+// the remote secret is synced.
+func createExternalSecret(envName, ns string, values map[string]string) *ExternalSecret {
+	remoteKey := externalSecretRemoteKey(envName)
+
+	spec := ExternalSecretSpec{
+		RefreshInterval: externalSecretsRefreshInterval,
+		SecretStoreRef: SecretStoreRef{
+			Name: externalSecretsStore,
+			Kind: externalSecretsStoreKind,
+		},
+		Target: ExternalSecretTarget{
+			Name:           appName,
+			CreationPolicy: "Owner",
+		},
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		spec.Data = append(spec.Data, ExternalSecretData{
+			SecretKey: key,
+			RemoteRef: ExternalSecretRef{Key: remoteKey, Property: key},
+		})
+	}
+	if len(keys) == 0 {
+		spec.DataFrom = []ExternalSecretDataFrom{{Extract: ExternalSecretRef{Key: remoteKey}}}
+	}
+
+	return &ExternalSecret{
+		APIVersion: "external-secrets.io/v1",
+		Kind:       "ExternalSecret",
+		Metadata: Metadata{
+			Name:      appName,
+			Namespace: ns,
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:str\]$`)
+
+// Decrypt a value the way sops does: AES-GCM with a 32-byte IV and the
+// value's path as additional data
+func sopsDecrypt(t *testing.T, value string, key []byte, additionalData string) string {
+	t.Helper()
+	match := sopsValuePattern.FindStringSubmatch(value)
+	if match == nil {
+		t.Fatalf("%q is not a sops encrypted value", value)
+	}
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			t.Fatalf("%q: %v", value, err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	if len(iv) != 32 {
+		t.Fatalf("%q: IV has %d bytes, sops uses 32", value, len(iv))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		t.Fatalf("%q with additional data %q: %v", value, additionalData, err)
+	}
+	return string(plaintext)
+}
+
+// Decrypt the values under encrypted keys in place and hash every value in
+// document order, skipping the sops metadata
+func sopsDecryptNode(t *testing.T, node *yaml.Node, path []string, encrypted bool, key []byte, mac hash.Hash) {
+	t.Helper()
+	encryptedRegex := regexp.MustCompile(sopsEncryptedRegex)
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			if len(path) == 0 && name == "sops" {
+				continue
+			}
+			sopsDecryptNode(t, node.Content[i+1], append(path, name), encrypted || encryptedRegex.MatchString(name), key, mac)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			sopsDecryptNode(t, item, path, encrypted, key, mac)
+		}
+	case yaml.ScalarNode:
+		if encrypted && node.Value != "" {
+			node.Value = sopsDecrypt(t, node.Value, key, strings.Join(path, ":")+":")
+		}
+		mac.Write([]byte(node.Value))
+	}
+}
+
+func TestSopsSecretRoundTrip(t *testing.T) {
+	identities := make([]*age.X25519Identity, 2)
+	var recipients []string
+	for i := range identities {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities[i] = identity
+		recipients = append(recipients, identity.Recipient().String())
+	}
+
+	secret := &Secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   Metadata{Name: "myapp", Namespace: "myapp-production", Labels: map[string]string{"app": "myapp"}},
+		Type:       "Opaque",
+		StringData: map[string]string{"DB_PASSWORD": "s3cr3t: with colon", "API_KEY": "abc123", "EMPTY": ""},
+	}
+	out, err := yaml.Marshal(&SopsSecret{Secret: secret, recipients: recipients})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file yaml.Node
+	if err := yaml.Unmarshal(out, &file); err != nil {
+		t.Fatal(err)
+	}
+	doc := file.Content[0]
+
+	var encrypted struct {
+		Metadata   Metadata          `yaml:"metadata"`
+		StringData map[string]string `yaml:"stringData"`
+		Sops       sopsMetadata      `yaml:"sops"`
+	}
+	if err := doc.Decode(&encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if encrypted.Metadata.Name != "myapp" || encrypted.Metadata.Labels["app"] != "myapp" {
+		t.Errorf("metadata must stay readable, got %+v", encrypted.Metadata)
+	}
+	if encrypted.StringData["EMPTY"] != "" {
+		t.Errorf("empty values must stay empty, got %q", encrypted.StringData["EMPTY"])
+	}
+	if encrypted.Sops.EncryptedRegex != sopsEncryptedRegex || len(encrypted.Sops.Age) != len(identities) {
+		t.Fatalf("unexpected sops metadata %+v", encrypted.Sops)
+	}
+
+	// Every recipient recovers the same data key
+	var dataKey []byte
+	for i, key := range encrypted.Sops.Age {
+		if key.Recipient != recipients[i] {
+			t.Errorf("recipient %d is %s, want %s", i, key.Recipient, recipients[i])
+		}
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(key.Enc)), identities[i])
+		if err != nil {
+			t.Fatalf("recipient %d: %v", i, err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decrypted) != 32 {
+			t.Fatalf("recipient %d: data key has %d bytes, want 32", i, len(decrypted))
+		}
+		if dataKey != nil && !bytes.Equal(dataKey, decrypted) {
+			t.Fatalf("recipient %d got a different data key", i)
+		}
+		dataKey = decrypted
+	}
+
+	mac := sha512.New()
+	sopsDecryptNode(t, doc, nil, false, dataKey, mac)
+	var decrypted Secret
+	if err := doc.Decode(&decrypted); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range secret.StringData {
+		if got := decrypted.StringData[key]; got != want {
+			t.Errorf("stringData.%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// The MAC is keyed by lastmodified, which makes it fail when the file
+	// is edited without sops
+	gotMAC := sopsDecrypt(t, encrypted.Sops.MAC, dataKey, encrypted.Sops.LastModified)
+	if want := fmt.Sprintf("%X", mac.Sum(nil)); gotMAC != want {
+		t.Errorf("MAC = %s, want %s", gotMAC, want)
+	}
+}
+
+// Unseal a value as the sealed-secrets controller does
+func hybridDecrypt(key *rsa.PrivateKey, ciphertext, label []byte) ([]byte, error) {
+	if len(ciphertext) < 2 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	keyLength := int(binary.BigEndian.Uint16(ciphertext))
+	if len(ciphertext) < 2+keyLength {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+keyLength], label)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, make([]byte, gcm.NonceSize()), ciphertext[2+keyLength:], nil)
+}
+
+func TestSealedSecretRoundTrip(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(scope string, key *rsa.PublicKey) {
+		sealedSecretsScope, sealedSecretsKey = scope, key
+	}(sealedSecretsScope, sealedSecretsKey)
+	sealedSecretsKey = &privateKey.PublicKey
+
+	values := map[string]string{"DB_PASSWORD": "s3cr3t", "API_KEY": "abc123"}
+	tests := []struct {
+		scope      string
+		label      string
+		annotation string
+	}{
+		{"strict", "myapp-production/myapp", ""},
+		{"namespace-wide", "myapp-production", "sealedsecrets.bitnami.com/namespace-wide"},
+		{"cluster-wide", "", "sealedsecrets.bitnami.com/cluster-wide"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			sealedSecretsScope = tt.scope
+			secret := &Secret{
+				Metadata:   Metadata{Name: "myapp", Namespace: "myapp-production"},
+				Type:       "Opaque",
+				StringData: values,
+			}
+			sealed, err := createSealedSecret(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.annotation != "" && sealed.Metadata.Annotations[tt.annotation] != "true" {
+				t.Errorf("missing annotation %s: %v", tt.annotation, sealed.Metadata.Annotations)
+			}
+			if sealed.Spec.Template.Metadata.Name != "myapp" || sealed.Spec.Template.Type != "Opaque" {
+				t.Errorf("unexpected template %+v", sealed.Spec.Template)
+			}
+			if len(sealed.Spec.EncryptedData) != len(values) {
+				t.Fatalf("sealed %d values, want %d", len(sealed.Spec.EncryptedData), len(values))
+			}
+
+			for key, want := range values {
+				ciphertext, err := base64.StdEncoding.DecodeString(sealed.Spec.EncryptedData[key])
+				if err != nil {
+					t.Fatalf("%s: %v", key, err)
+				}
+				plaintext, err := hybridDecrypt(privateKey, ciphertext, []byte(tt.label))
+				if err != nil {
+					t.Fatalf("%s: unsealing with label %q: %v", key, tt.label, err)
+				}
+				if string(plaintext) != want {
+					t.Errorf("%s = %q, want %q", key, plaintext, want)
+				}
+				// The label binds the value to its scope
+				if _, err := hybridDecrypt(privateKey, ciphertext, []byte("other/"+tt.label)); err == nil {
+					t.Errorf("%s: unsealed with the wrong label", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type StatefulSet struct {
+	APIVersion string          `yaml:"apiVersion"`
+	Kind       string          `yaml:"kind"`
+	Metadata   Metadata        `yaml:"metadata"`
+	Spec       StatefulSetSpec `yaml:"spec"`
+}
+
+type StatefulSetSpec struct {
+	ServiceName          string                          `yaml:"serviceName"`
+	Replicas             *int32                          `yaml:"replicas,omitempty"`
+	Selector             Selector                        `yaml:"selector"`
+	PodManagementPolicy  string                          `yaml:"podManagementPolicy,omitempty"`
+	UpdateStrategy       StatefulSetUpdateStrategy       `yaml:"updateStrategy"`
+	Template             PodTemplate                     `yaml:"template"`
+	VolumeClaimTemplates []PersistentVolumeClaimTemplate `yaml:"volumeClaimTemplates,omitempty"`
+}
+
+type StatefulSetUpdateStrategy struct {
+	Type          string                 `yaml:"type"`
+	RollingUpdate map[string]interface{} `yaml:"rollingUpdate,omitempty"`
+}
+
+type PersistentVolumeClaimTemplate struct {
+	Metadata Metadata                  `yaml:"metadata"`
+	Spec     PersistentVolumeClaimSpec `yaml:"spec"`
+}
+
+type PersistentVolumeClaimSpec struct {
+	AccessModes      []string             `yaml:"accessModes"`
+	StorageClassName string               `yaml:"storageClassName,omitempty"`
+	VolumeMode       string               `yaml:"volumeMode,omitempty"`
+	Resources        VolumeResourceValues `yaml:"resources"`
+}
+
+type VolumeResourceValues struct {
+	Requests map[string]string `yaml:"requests"`
+}
+
+// StatefulSetSection configures the StatefulSets in the config file
+type StatefulSetSection struct {
+	PodManagementPolicy  string                      `yaml:"podManagementPolicy"`
+	UpdateStrategy       string                      `yaml:"updateStrategy"`
+	Partition            *int                        `yaml:"partition"`
+	VolumeClaimTemplates []VolumeClaimTemplateConfig `yaml:"volumeClaimTemplates"`
+}
+
+// VolumeClaimTemplateConfig gives every StatefulSet pod its own volume,
+// mounted into the app container at mountPath
+type VolumeClaimTemplateConfig struct {
+	Name         string   `yaml:"name"`
+	MountPath    string   `yaml:"mountPath"`
+	Size         string   `yaml:"size"`
+	StorageClass string   `yaml:"storageClass"`
+	AccessModes  []string `yaml:"accessModes"`
+}
+
+var (
+	workload                     string
+	podManagementPolicy          string
+	statefulSetUpdateStrategy    string
+	statefulSetPartition         int
+	volumeClaimTemplateFlags     []string
+	volumeClaimTemplateConfigs   []VolumeClaimTemplateConfig
+	resolvedVolumeClaimTemplates []VolumeClaimTemplateConfig
+)
+
+var (
+	workloadKinds         = []string{"deployment", "statefulset"}
+	podManagementPolicies = []string{"OrderedReady", "Parallel"}
+	pvcAccessModes        = []string{"ReadWriteOnce", "ReadOnlyMany", "ReadWriteMany", "ReadWriteOncePod"}
+)
+
+// Check the StatefulSet settings and merge the --volume-claim-template flags
+// on top of the claim templates from the config file. Runs before the
+// components are resolved, as their mounts may refer to the claims.
+func resolveStatefulSet() error {
+	if !containsString(workloadKinds, workload) {
+		return fmt.Errorf("invalid --workload %q (expected deployment or statefulset)", workload)
+	}
+	if !containsString(podManagementPolicies, podManagementPolicy) {
+		return fmt.Errorf("invalid --pod-management-policy %q (expected OrderedReady or Parallel)", podManagementPolicy)
+	}
+	switch statefulSetUpdateStrategy {
+	case "RollingUpdate":
+	case "OnDelete":
+		if statefulSetPartition != 0 {
+			return fmt.Errorf("--statefulset-partition requires the RollingUpdate update strategy")
+		}
+	default:
+		return fmt.Errorf("invalid --statefulset-update-strategy %q (expected RollingUpdate or OnDelete)", statefulSetUpdateStrategy)
+	}
+	if statefulSetPartition < 0 {
+		return fmt.Errorf("--statefulset-partition must not be negative")
+	}
+
+	resolvedVolumeClaimTemplates = append([]VolumeClaimTemplateConfig(nil), volumeClaimTemplateConfigs...)
+	for _, spec := range volumeClaimTemplateFlags {
+		claim, err := parseVolumeClaimTemplate(spec)
+		if err != nil {
+			return fmt.Errorf("invalid --volume-claim-template %q: %w", spec, err)
+		}
+		replaced := false
+		for i, existing := range resolvedVolumeClaimTemplates {
+			if existing.Name == claim.Name {
+				resolvedVolumeClaimTemplates[i] = claim
+				replaced = true
+			}
+		}
+		if !replaced {
+			resolvedVolumeClaimTemplates = append(resolvedVolumeClaimTemplates, claim)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, claim := range resolvedVolumeClaimTemplates {
+		if !dns1123LabelPattern.MatchString(claim.Name) {
+			return fmt.Errorf("volume claim template %q: name must be a lowercase DNS label", claim.Name)
+		}
+		if names[claim.Name] {
+			return fmt.Errorf("duplicate volume claim template %q", claim.Name)
+		}
+		names[claim.Name] = true
+		if claim.MountPath == "" {
+			return fmt.Errorf("volume claim template %s requires a mountPath", claim.Name)
+		}
+		if claim.Size == "" {
+			return fmt.Errorf("volume claim template %s requires a size", claim.Name)
+		}
+		for _, mode := range claim.AccessModes {
+			if !containsString(pvcAccessModes, mode) {
+				return fmt.Errorf("volume claim template %s: invalid access mode %q (expected %s)", claim.Name, mode, strings.Join(pvcAccessModes, ", "))
+			}
+		}
+	}
+
+	// Only StatefulSets use these settings
+	if !usesStatefulSets() && (len(resolvedVolumeClaimTemplates) > 0 || statefulSetPartition != 0) {
+		return fmt.Errorf("volume claim templates and --statefulset-partition require --workload statefulset")
+	}
+	return nil
+}
+
+// Parse NAME,mountPath=PATH,size=SIZE[,storageClass=CLASS][,accessMode=MODE...]
+func parseVolumeClaimTemplate(spec string) (VolumeClaimTemplateConfig, error) {
+	parts := strings.Split(spec, ",")
+	claim := VolumeClaimTemplateConfig{Name: parts[0]}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return claim, fmt.Errorf("expected key=value, got %q", part)
+		}
+		switch kv[0] {
+		case "mountPath":
+			claim.MountPath = kv[1]
+		case "size":
+			claim.Size = kv[1]
+		case "storageClass":
+			claim.StorageClass = kv[1]
+		case "accessMode":
+			claim.AccessModes = append(claim.AccessModes, kv[1])
+		default:
+			return claim, fmt.Errorf("unknown key %q (expected mountPath, size, storageClass or accessMode)", kv[0])
+		}
+	}
+	return claim, nil
+}
+
+// Whether the default workload or any declared component is a StatefulSet
+func usesStatefulSets() bool {
+	if len(componentConfigs) == 0 {
+		return workload == "statefulset"
+	}
+	for _, c := range componentConfigs {
+		if c.Workload == "statefulset" || (c.Workload == "" && workload == "statefulset") {
+			return true
+		}
+	}
+	return false
+}
+
+// Mounts of the claim templates in the app container
+func volumeClaimMounts() []VolumeMount {
+	var mounts []VolumeMount
+	for _, claim := range resolvedVolumeClaimTemplates {
+		mounts = append(mounts, VolumeMount{Name: claim.Name, MountPath: claim.MountPath})
+	}
+	return mounts
+}
+
+// Kubernetes kind of the component's workload
+func (c ComponentConfig) workloadKind() string {
+	if c.Workload == "statefulset" {
+		return "StatefulSet"
+	}
+	return "Deployment"
+}
+
+// Name of the headless Service governing a StatefulSet
+func headlessServiceName(c ComponentConfig) string {
+	return fmt.Sprintf("%s-headless", c.deploymentName())
+}
+
+// Create StatefulSet resource for a component, with the same pod spec as its
+// Deployment would have and a volume per pod from each claim template
+func createStatefulSet(c ComponentConfig, tag, ns string, useConfigMap, useSecret bool, env []EnvVar) *StatefulSet {
+	selectorLabels := c.selectorLabels()
+
+	// Replicas are managed by the HPA when it is enabled
+	var replicasPtr *int32
+	if !hpaEnabled || c.Name != primaryComponent().Name {
+		replicasInt32 := int32(*c.Replicas)
+		replicasPtr = &replicasInt32
+	}
+
+	podSpec := createPodSpec(c, tag, useConfigMap, useSecret, env)
+	applyScheduling(&podSpec, selectorLabels)
+
+	updateStrategy := StatefulSetUpdateStrategy{Type: statefulSetUpdateStrategy}
+	if statefulSetPartition > 0 {
+		updateStrategy.RollingUpdate = map[string]interface{}{
+			"partition": statefulSetPartition,
+		}
+	}
+
+	var claims []PersistentVolumeClaimTemplate
+	for _, claim := range resolvedVolumeClaimTemplates {
+		accessModes := claim.AccessModes
+		if len(accessModes) == 0 {
+			accessModes = []string{"ReadWriteOnce"}
+		}
+		claims = append(claims, PersistentVolumeClaimTemplate{
+			Metadata: Metadata{
+				Name: claim.Name,
+			},
+			Spec: PersistentVolumeClaimSpec{
+				AccessModes:      accessModes,
+				StorageClassName: claim.StorageClass,
+				Resources: VolumeResourceValues{
+					Requests: map[string]string{"storage": claim.Size},
+				},
+			},
+		})
+	}
+
+	return &StatefulSet{
+		APIVersion: "apps/v1",
+		Kind:       "StatefulSet",
+		Metadata: Metadata{
+			Name:      c.deploymentName(),
+			Namespace: ns,
+		},
+		Spec: StatefulSetSpec{
+			ServiceName: headlessServiceName(c),
+			Replicas:    replicasPtr,
+			Selector: Selector{
+				MatchLabels: selectorLabels,
+			},
+			PodManagementPolicy: podManagementPolicy,
+			UpdateStrategy:      updateStrategy,
+			Template: PodTemplate{
+				Metadata: Metadata{
+					Labels: selectorLabels,
+				},
+				Spec: podSpec,
+			},
+			VolumeClaimTemplates: claims,
+		},
+	}
+}
+
+// Create the headless Service that gives each StatefulSet pod a stable DNS
+// name; pods are addressed directly, so it exposes the container port itself
+func createHeadlessService(c ComponentConfig, ns string) *Service {
+	service := &Service{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Metadata: Metadata{
+			Name:      headlessServiceName(c),
+			Namespace: ns,
+		},
+		Spec: ServiceSpec{
+			Type:      "ClusterIP",
+			ClusterIP: "None",
+			Selector:  c.selectorLabels(),
+		},
+	}
+	if *c.Port > 0 {
+		service.Spec.Ports = []ServicePort{
+			{
+				Port:       *c.Port,
+				TargetPort: "http-port",
+				Protocol:   "TCP",
+				Name:       "http",
+			},
+		}
+	}
+	return service
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Kubernetes version used when --kube-version is not set
+const defaultKubeVersion = "1.30"
+
+// Schemas kcg checks its own output against, with the Kubernetes versions
+// each apiVersion/kind is served in. They are written by hand from the
+// upstream OpenAPI definitions and only cover the kinds kcg generates, so
+// this is a self-check of kcg's output rather than a full validation against
+// the API server's schemas; see the $comment at the top of the file.
+//
+//go:embed schemas/kubernetes.json
+var kubernetesSchemas []byte
+
+var (
+	kubeVersion    string
+	skipValidation bool
+)
+
+type schemaSet struct {
+	Resources   []schemaResource       `json:"resources"`
+	Definitions map[string]*jsonSchema `json:"definitions"`
+}
+
+type schemaResource struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Introduced string      `json:"introduced"`
+	Removed    string      `json:"removed"`
+	CRD        bool        `json:"crd"`
+	Schema     *jsonSchema `json:"schema"`
+}
+
+// The subset of JSON schema used by schemas/kubernetes.json
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	PropertyNames        *jsonSchema            `json:"propertyNames"`
+	Required             []string               `json:"required"`
+	Items                *jsonSchema            `json:"items"`
+	AllOf                []*jsonSchema          `json:"allOf"`
+	Enum                 []interface{}          `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	Format               string                 `json:"format"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	IntOrString          bool                   `json:"x-kubernetes-int-or-string"`
+	Introduced           string                 `json:"x-kcg-introduced"`
+}
+
+// additionalProperties is either a boolean or a schema for the values
+type additionalProperties struct {
+	allowed bool
+	schema  *jsonSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// A single validation failure
+type schemaError struct {
+	file   string
+	path   string
+	reason string
+}
+
+func (e schemaError) String() string {
+	if e.path == "" {
+		return fmt.Sprintf("%s: %s", e.file, e.reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.file, e.path, e.reason)
+}
+
+type schemaValidator struct {
+	set   *schemaSet
+	minor int
+	file  string
+	errs  []schemaError
+}
+
+func newSchemaValidator(version string) (*schemaValidator, error) {
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	var set schemaSet
+	if err := json.Unmarshal(kubernetesSchemas, &set); err != nil {
+		return nil, fmt.Errorf("failed to load embedded schemas: %w", err)
+	}
+	return &schemaValidator{set: &set, minor: minor}, nil
+}
+
+// Parse 1.29, v1.29 or 1.29.3 into the minor version
+func parseKubeVersion(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid Kubernetes version %q (expected e.g. 1.29)", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid Kubernetes version %q (expected e.g. 1.29)", version)
+	}
+	return minor, nil
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, schemaError{file: v.file, path: path, reason: fmt.Sprintf(format, args...)})
+}
+
+// Validate one object; returns false if there is no schema for its kind
+func (v *schemaValidator) validateObject(file string, object map[string]interface{}) bool {
+	v.file = file
+	apiVersion, _ := object["apiVersion"].(string)
+	// sops keeps its metadata next to the encrypted fields
+	delete(object, "sops")
+	kind, _ := object["kind"].(string)
+
+	for _, resource := range v.set.Resources {
+		if resource.APIVersion != apiVersion || resource.Kind != kind {
+			continue
+		}
+		if !resource.CRD {
+			if resource.Removed != "" && v.minor >= mustMinor(resource.Removed) {
+				v.fail("apiVersion", "%s %s was removed in Kubernetes %s (validating for 1.%d)", apiVersion, kind, resource.Removed, v.minor)
+				return true
+			}
+			if resource.Introduced != "" && v.minor < mustMinor(resource.Introduced) {
+				v.fail("apiVersion", "%s %s is not served before Kubernetes %s (validating for 1.%d)", apiVersion, kind, resource.Introduced, v.minor)
+				return true
+			}
+		}
+		if resource.Schema != nil {
+			v.validate("", object, resource.Schema)
+		}
+		return true
+	}
+	return false
+}
+
+func mustMinor(version string) int {
+	minor, err := parseKubeVersion(version)
+	if err != nil {
+		panic(err)
+	}
+	return minor
+}
+
+func (v *schemaValidator) resolve(schema *jsonSchema) *jsonSchema {
+	for schema.Ref != "" {
+		schema = v.set.Definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")]
+	}
+	return schema
+}
+
+func (v *schemaValidator) validate(path string, value interface{}, schema *jsonSchema) {
+	schema = v.resolve(schema)
+
+	// null means unset
+	if value == nil {
+		return
+	}
+
+	if schema.Introduced != "" && v.minor < mustMinor(schema.Introduced) {
+		v.fail(path, "requires Kubernetes %s or later (validating for 1.%d)", schema.Introduced, v.minor)
+		return
+	}
+
+	for _, sub := range schema.AllOf {
+		v.validate(path, value, sub)
+	}
+
+	if schema.IntOrString {
+		switch val := value.(type) {
+		case int:
+			if schema.Format == "port" && (val < 1 || val > 65535) {
+				v.fail(path, "port %d is out of range (1-65535)", val)
+			}
+		case string:
+			v.validateString(path, val, schema)
+		default:
+			v.fail(path, "must be an integer or a string")
+		}
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(path, "must be an object")
+			return
+		}
+		v.validateProperties(path, object, schema)
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			v.fail(path, "must be a list")
+			return
+		}
+		if schema.Items != nil {
+			for i, item := range list {
+				v.validate(fmt.Sprintf("%s[%d]", path, i), item, schema.Items)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(path, "must be a string, got %v", value)
+			return
+		}
+		v.validateString(path, s, schema)
+	case "integer":
+		n, ok := value.(int)
+		if !ok {
+			v.fail(path, "must be an integer, got %v", value)
+			return
+		}
+		if schema.Minimum != nil && float64(n) < *schema.Minimum {
+			v.fail(path, "must be at least %v, got %d", *schema.Minimum, n)
+		}
+		if schema.Maximum != nil && float64(n) > *schema.Maximum {
+			v.fail(path, "must be at most %v, got %d", *schema.Maximum, n)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(path, "must be true or false, got %v", value)
+		}
+	}
+}
+
+func (v *schemaValidator) validateProperties(path string, object map[string]interface{}, schema *jsonSchema) {
+	for _, name := range schema.Required {
+		if object[name] == nil {
+			v.fail(joinPath(path, name), "is required")
+		}
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := joinPath(path, key)
+		if schema.PropertyNames != nil {
+			if reason := checkFormat(v.resolve(schema.PropertyNames).Format, key); reason != "" {
+				v.fail(keyPath, "invalid key: %s", reason)
+			}
+		}
+		if prop, ok := schema.Properties[key]; ok {
+			v.validate(keyPath, object[key], prop)
+			continue
+		}
+		if schema.AdditionalProperties == nil {
+			continue
+		}
+		if !schema.AdditionalProperties.allowed {
+			v.fail(keyPath, "unknown field")
+			continue
+		}
+		if schema.AdditionalProperties.schema != nil {
+			v.validate(keyPath, object[key], schema.AdditionalProperties.schema)
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(path, s string, schema *jsonSchema) {
+	if len(schema.Enum) > 0 {
+		valid := false
+		var allowed []string
+		for _, e := range schema.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+			if reflect.DeepEqual(e, s) {
+				valid = true
+			}
+		}
+		if !valid {
+			v.fail(path, "%q is not one of %s", s, strings.Join(allowed, ", "))
+		}
+	}
+	if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(s) {
+		v.fail(path, "%q does not match %s", s, schema.Pattern)
+	}
+	if reason := checkFormat(schema.Format, s); reason != "" {
+		v.fail(path, "%s", reason)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if strings.ContainsAny(key, "./") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	return path + "." + key
+}
+
+var (
+	dns1123LabelPattern     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	dns1123SubdomainPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	dns1035LabelPattern     = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+	qualifiedNamePattern    = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	labelValuePattern       = regexp.MustCompile(`^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$`)
+	quantityPattern         = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)(Ki|Mi|Gi|Ti|Pi|Ei|[numkMGTPE]|[eE][+-]?[0-9]+)?$`)
+	portNamePattern         = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	configKeyPattern        = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// Check a string against a named format; returns the reason it is invalid
+func checkFormat(format, s string) string {
+	switch format {
+	case "dns1123-label":
+		if len(s) > 63 || !dns1123LabelPattern.MatchString(s) {
+			return fmt.Sprintf("%q must be a lowercase RFC 1123 label (a-z, 0-9, '-', at most 63 characters)", s)
+		}
+	case "dns1123-subdomain":
+		if len(s) > 253 || !dns1123SubdomainPattern.MatchString(s) {
+			return fmt.Sprintf("%q must be a lowercase RFC 1123 subdomain (a-z, 0-9, '-', '.', at most 253 characters)", s)
+		}
+	case "dns1035-label":
+		if len(s) > 63 || !dns1035LabelPattern.MatchString(s) {
+			return fmt.Sprintf("%q must be a lowercase RFC 1035 label (start with a letter; a-z, 0-9, '-', at most 63 characters)", s)
+		}
+	case "wildcard-dns1123-subdomain":
+		host := strings.TrimPrefix(s, "*.")
+		if net.ParseIP(host) != nil {
+			return fmt.Sprintf("%q must be a DNS name, not an IP address", s)
+		}
+		if len(s) > 253 || !dns1123SubdomainPattern.MatchString(host) {
+			return fmt.Sprintf("%q must be a lowercase DNS name, optionally starting with '*.'", s)
+		}
+	case "qualified-name":
+		name := s
+		if idx := strings.LastIndex(s, "/"); idx >= 0 {
+			prefix := s[:idx]
+			name = s[idx+1:]
+			if len(prefix) > 253 || !dns1123SubdomainPattern.MatchString(prefix) {
+				return fmt.Sprintf("%q must have a DNS subdomain prefix", s)
+			}
+		}
+		if len(name) > 63 || !qualifiedNamePattern.MatchString(name) {
+			return fmt.Sprintf("%q must be a qualified name (alphanumerics, '-', '_', '.', at most 63 characters, optional DNS prefix)", s)
+		}
+	case "label-value":
+		if len(s) > 63 || !labelValuePattern.MatchString(s) {
+			return fmt.Sprintf("%q must be a valid label value (alphanumerics, '-', '_', '.', at most 63 characters)", s)
+		}
+	case "quantity":
+		if !quantityPattern.MatchString(s) {
+			return fmt.Sprintf("%q is not a valid quantity (e.g. 500m, 1.5, 512Mi, 2Gi)", s)
+		}
+	case "port", "port-name":
+		if len(s) > 15 || !portNamePattern.MatchString(s) || strings.Contains(s, "--") || !strings.ContainsAny(s, "abcdefghijklmnopqrstuvwxyz") {
+			return fmt.Sprintf("%q must be a port name (lowercase alphanumerics and '-', at most 15 characters, at least one letter)", s)
+		}
+	case "config-key":
+		if len(s) > 253 || !configKeyPattern.MatchString(s) {
+			return fmt.Sprintf("%q must consist of alphanumerics, '-', '_' or '.'", s)
+		}
+	case "env-var-name":
+		if !envVarNamePattern.MatchString(s) {
+			return fmt.Sprintf("%q is not a valid environment variable name", s)
+		}
+	case "base64":
+		// Values encrypted by sops are only base64 once decrypted
+		if strings.HasPrefix(s, "ENC[AES256_GCM,") {
+			break
+		}
+		if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+			return fmt.Sprintf("%q is not valid base64", s)
+		}
+	case "cidr":
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return fmt.Sprintf("%q is not a valid CIDR (e.g. 10.0.0.0/16)", s)
+		}
+	case "cron":
+		if err := validateCronSchedule(s); err != nil {
+			return fmt.Sprintf("%q is not a valid cron schedule: %s", s, err)
+		}
+	case "image":
+		if s == "" || strings.ContainsAny(s, " \t\n") {
+			return fmt.Sprintf("%q is not a valid image reference", s)
+		}
+	}
+	return ""
+}
+
+// Check generated manifests before they are written
+func validateManifests(manifests []interface{}, dir string) error {
+	if skipValidation {
+		return nil
+	}
+	validator, err := newSchemaValidator(kubeVersion)
+	if err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		// Encryption keeps the structure, so check the plaintext Secret
+		if s, ok := manifest.(*SopsSecret); ok {
+			manifest = s.Secret
+		}
+		object, err := toObject(manifest)
+		if err != nil {
+			return err
+		}
+		validator.validateObject(path.Join(dir, manifestFileName(manifest)), object)
+	}
+	if len(validator.errs) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(validator.errs))
+	for i, e := range validator.errs {
+		lines[i] = e.String()
+	}
+	return fmt.Errorf("generated manifests failed the self-check for Kubernetes 1.%d (use --skip-validation to write them anyway):\n  %s", validator.minor, strings.Join(lines, "\n  "))
+}
+
+func newCheckCommand() *cobra.Command {
+	checkCmd := &cobra.Command{
+		Use:     "check PATH...",
+		Aliases: []string{"validate"},
+		Short:   "Check manifest files or directories against the schemas of the kinds kcg generates, offline",
+		Args:    cobra.MinimumNArgs(1),
+		RunE:    runCheck,
+	}
+	checkCmd.Flags().StringVar(&kubeVersion, "kube-version", defaultKubeVersion, "Kubernetes version to check against")
+	return checkCmd
+}
+
+func runCheck(cmd *cobra.Command, args []string) error {
+	validator, err := newSchemaValidator(kubeVersion)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, arg := range args {
+		err := filepath.Walk(arg, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !isManifestFile(p) {
+				return nil
+			}
+			files = append(files, p)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", arg, err)
+		}
+	}
+	if len(files) == 0 {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		return fmt.Errorf("no manifest files (.yaml, .yml, .json or .ndjson) found in %s", strings.Join(args, ", "))
+	}
+
+	objects, skipped := 0, 0
+	for _, file := range files {
+		docs, err := readManifestDocuments(file)
+		if err != nil {
+			validator.errs = append(validator.errs, schemaError{file: file, reason: err.Error()})
+			continue
+		}
+		for i, doc := range docs {
+			label := file
+			if len(docs) > 1 {
+				label = fmt.Sprintf("%s (document %d)", file, i+1)
+			}
+			if doc["apiVersion"] == nil || doc["kind"] == nil {
+				// Not a Kubernetes object, e.g. Helm values
+				skipped++
+				continue
+			}
+			if !validator.validateObject(label, doc) {
+				warnf("%s: no schema for %v %v, skipped", label, doc["apiVersion"], doc["kind"])
+				skipped++
+				continue
+			}
+			objects++
+		}
+	}
+
+	for _, e := range validator.errs {
+		pterm.Error.WithWriter(os.Stderr).Println(e.String())
+	}
+	if len(validator.errs) > 0 {
+		// Invalid manifests are not a usage error
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		return fmt.Errorf("%d error(s) in %d object(s)", len(validator.errs), objects)
+	}
+	pterm.Success.Printf("%d object(s) passed the self-check for Kubernetes 1.%d (%d document(s) skipped)\n", objects, validator.minor, skipped)
+	return nil
+}
+
+// YAML and JSON files as --output-format writes them, except kustomize
+// files that are not complete objects
+func isManifestFile(p string) bool {
+	base := filepath.Base(p)
+	if base == "kustomization.yaml" || strings.HasSuffix(base, "-patch.yaml") {
+		return false
+	}
+	switch filepath.Ext(base) {
+	case ".yaml", ".yml", ".json", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// Objects of a manifest file: one per line in NDJSON, otherwise YAML
+// documents (JSON being YAML), with the items of a List expanded
+func readManifestDocuments(file string) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	if filepath.Ext(file) == ".ndjson" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var doc map[string]interface{}
+			if err := yaml.Unmarshal([]byte(line), &doc); err != nil {
+				return nil, fmt.Errorf("invalid JSON on line %d: %w", i+1, err)
+			}
+			docs = append(docs, doc)
+		}
+	} else {
+		var err error
+		if docs, err = readYAMLDocuments(file); err != nil {
+			return nil, err
+		}
+	}
+
+	var objects []map[string]interface{}
+	for _, doc := range docs {
+		items, ok := doc["items"].([]interface{})
+		if doc["kind"] != "List" || !ok {
+			objects = append(objects, doc)
+			continue
+		}
+		for _, item := range items {
+			if object, ok := item.(map[string]interface{}); ok {
+				objects = append(objects, object)
+			}
+		}
+	}
+	return objects, nil
+}
+
+func readYAMLDocuments(file string) ([]map[string]interface{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var docs []map[string]interface{}
+	decoder := yaml.NewDecoder(f)
+	for {
+		var doc map[string]interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}